@@ -1,14 +1,12 @@
 package broker
 
 import (
-	"fmt"
-	"github.com/cloudfoundry-community/brooklyn-plugin/assert"
 	"github.com/cloudfoundry/cli/cf/errors"
 	"github.com/cloudfoundry/cli/plugin"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"strings"
+	"time"
 )
 
 type BrokerCredentials struct {
@@ -21,38 +19,53 @@ func NewBrokerCredentials(broker, username, password string) *BrokerCredentials
 	return &BrokerCredentials{broker, username, password}
 }
 
+// RequestTimeout limits how long SendRequest waits for the broker, or
+// is 0 to wait for as long as it takes.
+var RequestTimeout time.Duration
+
+// SendRequest sends a request to the broker and returns the response
+// body. A response with a status other than 2xx is returned as a
+// *BrokerError.
 func SendRequest(req *http.Request) ([]byte, error) {
-	client := &http.Client{}
+	client := &http.Client{Timeout: RequestTimeout}
 	resp, err := client.Do(req)
-	assert.ErrorIsNil(err)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
-	if resp.Status != "200 OK" {
-		fmt.Println("response Status:", resp.Status)
-		fmt.Println("response Headers:", resp.Header)
-		fmt.Println("response Body:", string(body))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newBrokerError(req, resp, body)
 	}
-	return body, err
+	return body, nil
 }
 
 func ServiceBrokerUrl(cliConnection plugin.CliConnection, broker string) (string, error) {
 	brokers, err := cliConnection.CliCommandWithoutTerminalOutput("service-brokers")
-	assert.Condition(err == nil, "could not get service broker url")
+	if err != nil {
+		return "", errors.New("could not get service broker url")
+	}
 	for _, a := range brokers {
 		fields := strings.Fields(a)
-		if fields[0] == broker {
+		if len(fields) >= 2 && fields[0] == broker {
 			return fields[1], nil
 		}
 	}
-	return "", errors.New("No such broker")
+	return "", &NoSuchBrokerError{broker}
 }
 
-func CreateRestCallUrlString(cliConnection plugin.CliConnection, cred *BrokerCredentials, path string) string {
-	brokerUrl, err := ServiceBrokerUrl(cliConnection, cred.Broker)
-	assert.Condition(err == nil, "No such broker")
-	brooklynUrl, err := url.Parse(brokerUrl)
-	assert.Condition(err == nil, "Can't parse url")
-	brooklynUrl.Path = path
-	brooklynUrl.User = url.UserPassword(cred.Username, cred.Password)
-	return brooklynUrl.String()
+// ServiceGuid looks up the guid of a named service instance in the
+// currently targeted space.
+func ServiceGuid(cliConnection plugin.CliConnection, service string) (string, error) {
+	guid, err := cliConnection.CliCommandWithoutTerminalOutput("service", service, "--guid")
+	if err != nil {
+		return "", err
+	}
+	if len(guid) == 0 || strings.TrimSpace(guid[0]) == "" {
+		return "", errors.New("No such service " + service)
+	}
+	return strings.TrimSpace(guid[0]), nil
 }
@@ -0,0 +1,223 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cloudfoundry/cli/plugin"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const brokerApiVersion = "2.4"
+
+// Client is a typed client for the REST API exposed by the
+// Brooklyn service broker.
+type Client struct {
+	cliConnection plugin.CliConnection
+	credentials   *BrokerCredentials
+	brokerUrl     string
+	ctx           context.Context
+}
+
+func NewClient(cliConnection plugin.CliConnection, cred *BrokerCredentials) *Client {
+	client := new(Client)
+	client.cliConnection = cliConnection
+	client.credentials = cred
+	return client
+}
+
+// WithContext returns a copy of the client whose requests are abandoned
+// once ctx is done, such as when its deadline passes.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	client := *c
+	client.ctx = ctx
+	return &client
+}
+
+// CreateCatalogItem submits a blueprint to be added to the Brooklyn catalog.
+func (c *Client) CreateCatalogItem(blueprint io.Reader) error {
+	_, err := c.send("POST", "create", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, blueprint)
+	return err
+}
+
+// DeleteCatalogItem removes a version of an item from the Brooklyn catalog.
+func (c *Client) DeleteCatalogItem(name, version string) error {
+	_, err := c.send("DELETE", escapePath("delete", name, version)+"/", nil, nil)
+	return err
+}
+
+// Sensors returns the sensor values of every entity of the service
+// instance with the given guid, keyed by entity name.
+func (c *Client) Sensors(guid string) (SensorTree, error) {
+	var sensors SensorTree
+	err := c.getJSON(escapePath("sensors", guid), &sensors)
+	return sensors, err
+}
+
+// Effectors returns the effectors that can be invoked on the entities
+// of the service instance with the given guid.
+func (c *Client) Effectors(guid string) (*EffectorTree, error) {
+	effectors := new(EffectorTree)
+	err := c.getJSON(escapePath("effectors", guid), effectors)
+	return effectors, err
+}
+
+// Invoke invokes an effector on an entity of the service instance with
+// the given guid and returns the result reported by the broker.
+func (c *Client) Invoke(guid, entity, effector string, params map[string]interface{}) (string, error) {
+	post, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	path := escapePath("invoke", guid, entity, effector)
+	body, err := c.send("POST", path, map[string]string{"Content-Type": "application/json"}, bytes.NewReader(post))
+	return string(body), err
+}
+
+// InvokeAsync starts an effector without waiting for it to finish and
+// returns the task running it.
+func (c *Client) InvokeAsync(guid, entity, effector string, params map[string]interface{}) (*Task, error) {
+	post, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	path := escapePath("invoke", guid, entity, effector) + "?timeout=0"
+	body, err := c.send("POST", path, map[string]string{"Content-Type": "application/json"}, bytes.NewReader(post))
+	if err != nil {
+		return nil, err
+	}
+	// a broker without tasks ignores the timeout and answers with the
+	// result of the effector
+	task := new(Task)
+	if json.Unmarshal(body, task) != nil || task.Id == "" {
+		return nil, &TasksUnsupportedError{Result: string(body)}
+	}
+	return task, nil
+}
+
+// SupportsTasks reports whether the broker lists the tasks of the
+// service instance with the given guid, which brokers that can invoke
+// effectors asynchronously do.
+func (c *Client) SupportsTasks(guid string) (bool, error) {
+	_, err := c.send("GET", escapePath("task", guid), nil, nil)
+	var brokerErr *BrokerError
+	if errors.As(err, &brokerErr) {
+		switch brokerErr.StatusCode {
+		case http.StatusNotFound, http.StatusMethodNotAllowed:
+			return false, nil
+		}
+	}
+	return err == nil, err
+}
+
+// Task returns the current state of a task of the service instance with
+// the given guid.
+func (c *Client) Task(guid, id string) (*Task, error) {
+	task := new(Task)
+	err := c.getJSON(escapePath("task", guid, id), task)
+	var brokerErr *BrokerError
+	if errors.As(err, &brokerErr) && brokerErr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("no task %s, or %w", id, &TasksUnsupportedError{})
+	}
+	return task, err
+}
+
+// IsRunning reports whether the service instance with the given guid
+// has been provisioned and is running.
+func (c *Client) IsRunning(guid string) (bool, error) {
+	body, err := c.send("GET", escapePath("is-running", guid), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(strings.TrimSpace(string(body)))
+}
+
+// Catalog returns the service catalog the broker advertises to Cloud
+// Foundry. As this requires authentication it also serves to check
+// the credentials.
+func (c *Client) Catalog() (*Catalog, error) {
+	body, err := c.send("GET", "v2/catalog", map[string]string{"X-Broker-Api-Version": brokerApiVersion}, nil)
+	if err != nil {
+		return nil, err
+	}
+	catalog := new(Catalog)
+	return catalog, json.Unmarshal(body, catalog)
+}
+
+func (c *Client) getJSON(path string, v interface{}) error {
+	body, err := c.send("GET", path, nil, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (c *Client) send(method, path string, headers map[string]string, body io.Reader) ([]byte, error) {
+	restUrl, err := c.restCallUrl(path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, restUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.ctx != nil {
+		req = req.WithContext(c.ctx)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return SendRequest(req)
+}
+
+// BrokerUrl returns the URL of the broker, asking the CLI for it the
+// first time only. Once it has been called, the client makes no more
+// calls to the CLI, so it can be shared by goroutines.
+func (c *Client) BrokerUrl() (string, error) {
+	if c.brokerUrl == "" {
+		brokerUrl, err := ServiceBrokerUrl(c.cliConnection, c.credentials.Broker)
+		if err != nil {
+			return "", err
+		}
+		c.brokerUrl = brokerUrl
+	}
+	return c.brokerUrl, nil
+}
+
+// escapePath joins the segments into a path, escaping each one so that
+// names containing slashes, colons or spaces stay a single segment.
+func escapePath(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	return strings.Join(escaped, "/")
+}
+
+// restCallUrl returns the broker URL of an escaped path, which may end
+// in a query.
+func (c *Client) restCallUrl(path string) (string, error) {
+	brokerUrl, err := c.BrokerUrl()
+	if err != nil {
+		return "", err
+	}
+	brooklynUrl, err := url.Parse(brokerUrl)
+	if err != nil {
+		return "", err
+	}
+	if query := strings.Index(path, "?"); query >= 0 {
+		path, brooklynUrl.RawQuery = path[:query], path[query+1:]
+	}
+	if brooklynUrl.Path, err = url.PathUnescape(path); err != nil {
+		return "", err
+	}
+	brooklynUrl.RawPath = path
+	brooklynUrl.User = url.UserPassword(c.credentials.Username, c.credentials.Password)
+	return brooklynUrl.String(), nil
+}
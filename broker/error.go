@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BrokerError is returned when the broker answers a REST call with
+// a status other than 2xx.
+type BrokerError struct {
+	StatusCode int
+	Status     string
+	Endpoint   string
+	Message    string
+}
+
+func (e *BrokerError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("broker request %s failed: %s", e.Endpoint, e.Status)
+	}
+	return fmt.Sprintf("broker request %s failed: %s: %s", e.Endpoint, e.Status, e.Message)
+}
+
+// NoSuchBrokerError is returned when no service broker of the given
+// name is registered with Cloud Foundry.
+type NoSuchBrokerError struct {
+	Broker string
+}
+
+func (e *NoSuchBrokerError) Error() string {
+	return fmt.Sprintf("No such broker %s, see cf service-brokers", e.Broker)
+}
+
+// TasksUnsupportedError is returned when the broker does not run
+// effectors as tasks that can be followed, which --async and the task
+// command need. Result holds what the broker answered instead, if it
+// ran the effector to completion.
+type TasksUnsupportedError struct {
+	Result string
+}
+
+func (e *TasksUnsupportedError) Error() string {
+	if e.Result == "" {
+		return "the broker does not support tasks, which --async and task need"
+	}
+	return fmt.Sprintf("the broker does not support tasks, so it ran the effector to completion instead, returning: %s", e.Result)
+}
+
+func newBrokerError(req *http.Request, resp *http.Response, body []byte) *BrokerError {
+	return &BrokerError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		// the request url carries the broker credentials, so only
+		// the method and path are reported
+		Endpoint: req.Method + " " + req.URL.Path,
+		Message:  decodeErrorMessage(resp, body),
+	}
+}
+
+// decodeErrorMessage extracts the error message from a broker response,
+// which is either a JSON document or plain text.
+func decodeErrorMessage(resp *http.Response, body []byte) string {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err == nil {
+		for _, key := range []string{"description", "message", "error"} {
+			if message, found := doc[key].(string); found && message != "" {
+				return message
+			}
+		}
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return ""
+	}
+	return strings.TrimSpace(string(body))
+}
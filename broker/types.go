@@ -0,0 +1,209 @@
+package broker
+
+import (
+	"encoding/json"
+)
+
+// SensorTree holds the sensors of a service instance's entities,
+// keyed by entity name.
+type SensorTree map[string]*EntitySensors
+
+// Walk calls fn for every entity in the tree, passing the names of the
+// entity and its ancestors.
+func (t SensorTree) Walk(fn func(path []string, entity *EntitySensors)) {
+	t.walk(nil, fn)
+}
+
+func (t SensorTree) walk(parent []string, fn func(path []string, entity *EntitySensors)) {
+	for name, entity := range t {
+		path := append(append([]string{}, parent...), name)
+		fn(path, entity)
+		entity.Children.walk(path, fn)
+	}
+}
+
+// EntitySensors holds the sensor values published by a single entity
+// together with the sensors of its children.
+type EntitySensors struct {
+	Sensors  map[string]interface{}
+	Children SensorTree
+}
+
+func (e *EntitySensors) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	e.Sensors = make(map[string]interface{})
+	for k, v := range fields {
+		if k == "children" {
+			if err := json.Unmarshal(v, &e.Children); err != nil {
+				return err
+			}
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(v, &value); err != nil {
+			return err
+		}
+		e.Sensors[k] = value
+	}
+	return nil
+}
+
+// MarshalJSON writes the entity in the form the broker sends it, with
+// its children alongside its sensors.
+func (e *EntitySensors) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{})
+	for k, v := range e.Sensors {
+		fields[k] = v
+	}
+	if len(e.Children) > 0 {
+		fields["children"] = e.Children
+	}
+	return json.Marshal(fields)
+}
+
+// EffectorTree holds the effectors of a service instance's entities,
+// keyed by entity name, along with those of any child entities. The
+// broker lists the children of an application beside it rather than
+// within it, so Children belong to the entity when there is only one.
+type EffectorTree struct {
+	Entities map[string]*EntityEffectors
+	Children *EffectorTree
+}
+
+func (t *EffectorTree) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	t.Entities = make(map[string]*EntityEffectors)
+	for k, v := range fields {
+		if k == "children" {
+			if err := json.Unmarshal(v, &t.Children); err != nil {
+				return err
+			}
+			continue
+		}
+		entity := new(EntityEffectors)
+		if err := json.Unmarshal(v, entity); err != nil {
+			return err
+		}
+		t.Entities[k] = entity
+	}
+	return nil
+}
+
+// Walk calls fn for every entity in the tree, passing the names of the
+// entity and its ancestors.
+func (t *EffectorTree) Walk(fn func(path []string, entity *EntityEffectors)) {
+	t.walk(nil, fn)
+}
+
+func (t *EffectorTree) walk(parent []string, fn func(path []string, entity *EntityEffectors)) {
+	for name, entity := range t.Entities {
+		path := append(append([]string{}, parent...), name)
+		fn(path, entity)
+		if entity.Children != nil {
+			entity.Children.walk(path, fn)
+		}
+	}
+	if t.Children != nil {
+		path := parent
+		if name, found := t.ChildrenOwner(); found {
+			path = append(append([]string{}, parent...), name)
+		}
+		t.Children.walk(path, fn)
+	}
+}
+
+// ChildrenOwner returns the name of the entity that Children belong to,
+// which is known only when the tree has a single entity.
+func (t *EffectorTree) ChildrenOwner() (string, bool) {
+	if len(t.Entities) != 1 {
+		return "", false
+	}
+	for name := range t.Entities {
+		return name, true
+	}
+	return "", false
+}
+
+// EntityEffectors holds the effectors of a single entity, keyed by
+// effector name, along with the effectors of its children.
+type EntityEffectors struct {
+	Effectors map[string]*Effector
+	Children  *EffectorTree
+}
+
+func (e *EntityEffectors) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	e.Effectors = make(map[string]*Effector)
+	for k, v := range fields {
+		if k == "children" {
+			if err := json.Unmarshal(v, &e.Children); err != nil {
+				return err
+			}
+			continue
+		}
+		effector := new(Effector)
+		if err := json.Unmarshal(v, effector); err != nil {
+			return err
+		}
+		e.Effectors[k] = effector
+	}
+	return nil
+}
+
+type Effector struct {
+	Description string               `json:"description"`
+	Parameters  []*EffectorParameter `json:"parameters"`
+}
+
+type EffectorParameter struct {
+	Name         string      `json:"name"`
+	Type         string      `json:"type"`
+	Description  string      `json:"description"`
+	DefaultValue interface{} `json:"defaultValue"`
+}
+
+type Catalog struct {
+	Services []*CatalogService `json:"services"`
+}
+
+type CatalogService struct {
+	Id          string         `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Plans       []*CatalogPlan `json:"plans"`
+}
+
+type CatalogPlan struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Task is the Brooklyn summary of a task, such as a running effector.
+// Times are in milliseconds since the epoch, and zero if not yet
+// reached.
+type Task struct {
+	Id            string      `json:"id"`
+	DisplayName   string      `json:"displayName"`
+	CurrentStatus string      `json:"currentStatus"`
+	SubmitTimeUtc int64       `json:"submitTimeUtc"`
+	StartTimeUtc  int64       `json:"startTimeUtc"`
+	EndTimeUtc    int64       `json:"endTimeUtc"`
+	IsError       bool        `json:"isError"`
+	IsCancelled   bool        `json:"isCancelled"`
+	Result        interface{} `json:"result"`
+}
+
+// IsDone reports whether the task has finished, successfully or not.
+func (t *Task) IsDone() bool {
+	return t.EndTimeUtc > 0 || t.IsError || t.IsCancelled
+}
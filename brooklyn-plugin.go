@@ -1,173 +1,422 @@
 package main
 
 import (
-	"path/filepath"
 	"fmt"
-	"github.com/cloudfoundry-community/brooklyn-plugin/assert"
 	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
 	"github.com/cloudfoundry-community/brooklyn-plugin/catalog"
+	"github.com/cloudfoundry-community/brooklyn-plugin/credentials"
 	"github.com/cloudfoundry-community/brooklyn-plugin/effectors"
-	"github.com/cloudfoundry-community/brooklyn-plugin/io"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"github.com/cloudfoundry-community/brooklyn-plugin/flags"
+	"github.com/cloudfoundry-community/brooklyn-plugin/output"
 	"github.com/cloudfoundry-community/brooklyn-plugin/push"
 	"github.com/cloudfoundry-community/brooklyn-plugin/sensors"
+	"github.com/cloudfoundry-community/brooklyn-plugin/tree"
 	"github.com/cloudfoundry/cli/cf/terminal"
-	"github.com/cloudfoundry/cli/generic"
 	"github.com/cloudfoundry/cli/plugin"
 	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
 type BrooklynPlugin struct {
 	ui            terminal.UI
 	cliConnection plugin.CliConnection
-	yamlMap       generic.Map
+	store         *credentials.Store
 	credentials   *broker.BrokerCredentials
 }
 
-func (c *BrooklynPlugin) printHelp(name string) {
-	metadata := c.GetMetadata()
-	for _, command := range metadata.Commands {
-		if command.Name == name{
-			fmt.Println("Name:")
-			fmt.Printf("    %-s - %-s\n", command.Name, command.HelpText)
-			fmt.Println("Usage:")
-			fmt.Printf("    %-s\n", command.UsageDetails.Usage)
-			return
+// brokerFlags are accepted by every command that talks to the broker,
+// and take precedence over the target stored by login.
+type brokerFlags struct {
+	broker   string
+	username string
+	password string
+	timeout  time.Duration
+}
+
+func (b *brokerFlags) register(command string, flagSet *flags.FlagSet) {
+	flagSet.StringVar(&b.broker, "broker")
+	flagSet.StringVar(&b.username, "username")
+	flagSet.StringVar(&b.password, "password")
+	// push has a --timeout of its own, for the whole wait for services
+	if command != "push" {
+		flagSet.DurationVar(&b.timeout, "timeout")
+	}
+}
+
+var brokerOptions = map[string]string{
+	"broker":   "Broker to use instead of the target stored by login",
+	"username": "Broker username, overriding the stored one",
+	"password": "Broker password, overriding the stored one",
+	"timeout":  "Give up on a broker request after this long, such as 30s; by default there is no limit",
+}
+
+var treeOptions = map[string]string{
+	"no-color": "Print the tree without colors",
+	"depth":    "Only show this many levels of entities",
+}
+
+func withBrokerOptions(options ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for k, v := range brokerOptions {
+		merged[k] = v
+	}
+	for _, extra := range options {
+		for k, v := range extra {
+			merged[k] = v
 		}
 	}
+	return merged
 }
 
-func (c *BrooklynPlugin) Run(cliConnection plugin.CliConnection, args []string) {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println(r)
+// commandFlags holds the values of the flags accepted by each command.
+type commandFlags struct {
+	help         bool
+	broker       brokerFlags
+	login        loginFlags
+	push         push.PushOptions
+	output       string
+	json         bool
+	sensorFilter sensors.Filter
+	watch        bool
+	interval     time.Duration
+	tree         tree.Options
+	invoke       effectors.InvokeOptions
+}
+
+// scriptOutput reports whether the command is writing output meant for
+// scripts, which nothing else should be mixed with.
+func (f *commandFlags) scriptOutput(command string) bool {
+	return command == "sensor" || output.IsMachineReadable(f.output)
+}
+
+func (f *commandFlags) register(command string, flagSet *flags.FlagSet) {
+	flagSet.BoolVar(&f.help, "h")
+	flagSet.BoolVar(&f.help, "help")
+	if !isCredentialsCommand(command) {
+		f.broker.register(command, flagSet)
+	}
+	switch command {
+	case "login":
+		f.login.register(flagSet)
+	case "push":
+		flagSet.StringVar(&f.push.Manifest, "f")
+		flagSet.BoolVar(&f.push.DryRun, "dry-run")
+		flagSet.BoolVar(&f.push.KeepServices, "keep-services")
+		flagSet.IntVar(&f.push.Parallel, "parallel")
+		flagSet.DurationVar(&f.push.Timeout, "timeout")
+		flagSet.DurationVar(&f.push.PollInterval, "poll-interval")
+		flagSet.DurationVar(&f.push.MaxInterval, "max-interval")
+		flagSet.PassThrough()
+	case "invoke":
+		flagSet.StringVar(&f.invoke.ParamsFile, "params-file")
+		flagSet.StringVar(&f.invoke.Params, "params")
+		flagSet.StringsVar(&f.invoke.Param, "param")
+		flagSet.BoolVar(&f.invoke.Wait, "wait")
+		flagSet.BoolVar(&f.invoke.Async, "async")
+		flagSet.PassThrough()
+	case "sensors":
+		f.registerOutput(flagSet)
+		flagSet.StringVar(&f.sensorFilter.Entity, "entity")
+		flagSet.StringVar(&f.sensorFilter.Sensor, "sensor")
+		flagSet.BoolVar(&f.watch, "watch")
+		flagSet.DurationVar(&f.interval, "interval")
+		f.registerTree(flagSet)
+	case "effectors":
+		f.registerOutput(flagSet)
+		f.registerTree(flagSet)
+	case "task":
+		f.registerOutput(flagSet)
+	}
+}
+
+func (f *commandFlags) registerOutput(flagSet *flags.FlagSet) {
+	flagSet.StringVar(&f.output, "output")
+	flagSet.BoolVar(&f.json, "json")
+}
+
+// outputFormat applies --json, which is short for --output json.
+func (f *commandFlags) outputFormat() error {
+	if !f.json {
+		return nil
+	}
+	if f.output != "" && f.output != output.JSON {
+		return failure.NewUsageError("--json cannot be used with --output %s", f.output)
+	}
+	f.output = output.JSON
+	return nil
+}
+
+func (f *commandFlags) registerTree(flagSet *flags.FlagSet) {
+	flagSet.BoolVar(&f.tree.NoColor, "no-color")
+	flagSet.IntVar(&f.tree.Depth, "depth")
+}
+
+func (c *BrooklynPlugin) findCommand(name string) (plugin.Command, bool) {
+	for _, command := range c.GetMetadata().Commands {
+		if command.Name == name {
+			return command, true
 		}
-	}()
-	argLength := len(args)
+	}
+	return plugin.Command{}, false
+}
+
+func (c *BrooklynPlugin) printHelp(name string) {
+	command, found := c.findCommand(name)
+	if !found {
+		return
+	}
+	fmt.Println("Name:")
+	fmt.Printf("    %-s - %-s\n", command.Name, command.HelpText)
+	fmt.Println("Usage:")
+	fmt.Printf("    %-s\n", command.UsageDetails.Usage)
+	if len(command.UsageDetails.Options) == 0 {
+		return
+	}
+	var options []string
+	for option := range command.UsageDetails.Options {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+	fmt.Println("Options:")
+	for _, option := range options {
+		flag := "--" + option
+		if len(option) == 1 {
+			flag = "-" + option
+		}
+		fmt.Printf("    %-20s %-s\n", flag, command.UsageDetails.Options[option])
+	}
+}
 
+func (c *BrooklynPlugin) Run(cliConnection plugin.CliConnection, args []string) {
 	c.ui = terminal.NewUI(os.Stdin, terminal.NewTeePrinter())
 	c.cliConnection = cliConnection
-	
-	if argLength == 1 {
+
+	if err := c.run(args); err != nil {
+		fmt.Println(terminal.FailureColor("FAILED"))
+		fmt.Println(err)
+		if failure.IsUsageError(err) && len(args) > 1 {
+			fmt.Println()
+			c.printHelp(args[0] + " " + args[1])
+		}
+		os.Exit(failure.ExitCode(err))
+	}
+}
+
+func (c *BrooklynPlugin) run(args []string) error {
+	if len(args) == 1 {
 		metadata := c.GetMetadata()
 		for _, command := range metadata.Commands {
 			fmt.Printf("%-25s %-50s\n", command.Name, command.HelpText)
 		}
-		return
+		return nil
 	}
-	
-	if argLength == 3 && args[2] == "-h" {
-		c.printHelp(args[0] + " " + args[1])
-		return
+
+	name := args[0] + " " + args[1]
+	if _, found := c.findCommand(name); !found {
+		return failure.NewUsageError("unknown command %q, see cf brooklyn for a list of commands", args[1])
 	}
 
-	// check to see if ~/.cf_brooklyn_plugin exists
-	// Then Parse it to get user credentials
-	home := os.Getenv("HOME")
-	file := filepath.Join(home, ".cf_brooklyn_plugin")
-	if _, err := os.Stat(file); os.IsNotExist(err) {
-		io.WriteYAMLFile(generic.NewMap(), file)
+	flagSet := flags.NewFlagSet(name)
+	parsed := commandFlags{
+		interval: 5 * time.Second,
+		push: push.PushOptions{
+			PollInterval: push.DefaultPollInterval,
+			MaxInterval:  push.DefaultMaxInterval,
+		},
+	}
+	parsed.register(args[1], flagSet)
+	if err := flagSet.Parse(args[2:]); err != nil {
+		return err
+	}
+	if parsed.help {
+		c.printHelp(name)
+		return nil
+	}
+	if err := parsed.outputFormat(); err != nil {
+		return err
+	}
+	if err := output.Validate(parsed.output); err != nil {
+		return err
 	}
-	yamlMap := io.ReadYAMLFile(file)
-	var target, username, password string
-	target, found := yamlMap.Get("target").(string)
-	if found {
-		auth, found := yamlMap.Get("auth").(map[interface{}]interface{})
-		if found {
-			creds := auth[target].(map[interface{}]interface{})
-			username, found = creds["username"].(string)
-			if found {
-				password, found = creds["password"].(string)
-			}
+	if parsed.tree.Depth < 0 {
+		return failure.NewUsageError("--depth cannot be negative")
+	}
+
+	if args[1] == "login" && parsed.login.nonInteractive() {
+		if err := parsed.login.checkNonInteractive(); err != nil {
+			return err
 		}
 	}
 
-	brokerCredentials := broker.NewBrokerCredentials(target, username, password)
+	scope, err := credentials.CurrentScope(c.cliConnection)
+	if err != nil {
+		return err
+	}
+	store, err := credentials.Load(credentials.DefaultPath(), scope, func() string {
+		return askOnStderr(func() string {
+			return c.ui.AskForPassword("Passphrase for stored credentials")
+		})
+	})
+	if err != nil {
+		return err
+	}
+	c.store = store
 
-	switch args[1] {
-	case "login":
-		broker := c.ui.Ask("Broker")
-		if !yamlMap.Has("auth"){
-			yamlMap.Set("auth", generic.NewMap())
-		}
-		auth := generic.NewMap(yamlMap.Get("auth"))
-		
-		if !auth.Has(broker) {
-			user := c.ui.Ask("Username")
-			pass := c.ui.AskForPassword("Password")
-			auth.Set(broker, generic.NewMap(map[string]string{
-				"username": user,
-				"password": pass,
-			}))
-		}
-		yamlMap.Set("target", broker)
-		io.WriteYAMLFile(yamlMap, file)
+	if isCredentialsCommand(args[1]) {
+		err = c.runCredentialsCommand(args[1], flagSet, parsed.login)
+	} else {
+		err = c.runBrokerCommand(args[1], flagSet, &parsed)
+	}
+	if err != nil {
+		return err
+	}
+	if !parsed.scriptOutput(args[1]) {
+		fmt.Println(terminal.ColorizeBold("OK", 32))
+	}
+	return nil
+}
+
+// askOnStderr asks a question with standard output pointing at
+// standard error, as the CLI prompts on standard output, which may be
+// carrying --output json or yaml.
+func askOnStderr(ask func() string) string {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+	return ask()
+}
+
+// legacyArgCounts gives the number of arguments each command took after
+// BROKER USERNAME PASSWORD in earlier versions of the plugin.
+var legacyArgCounts = map[string]int{
+	"add-catalog":    1,
+	"delete-catalog": 2,
+	"effectors":      1,
+	"sensors":        1,
+	"ready":          1,
+}
+
+// takeLegacyCredentials accepts the broker credentials given before the
+// other arguments, as earlier versions of the plugin took them, warning
+// that the form is deprecated.
+func takeLegacyCredentials(command string, flagSet *flags.FlagSet, override *brokerFlags) {
+	n, found := legacyArgCounts[command]
+	if !found || len(flagSet.Args()) != n+3 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Giving BROKER USERNAME PASSWORD as arguments is deprecated and will be removed in the next release, "+
+		"use cf brooklyn login or --broker, --username and --password instead.\n")
+	legacy := flagSet.TakeArgs(3)
+	override.broker, override.username, override.password = legacy[0], legacy[1], legacy[2]
+}
+
+func (c *BrooklynPlugin) runBrokerCommand(command string, flagSet *flags.FlagSet, parsed *commandFlags) error {
+	takeLegacyCredentials(command, flagSet, &parsed.broker)
+	var err error
+	c.credentials, err = c.brokerCredentials(parsed.broker)
+	if err != nil {
+		return err
+	}
+	broker.RequestTimeout = parsed.broker.timeout
+	args := flagSet.Args()
+
+	switch command {
 	case "push":
-		push.NewPushCommand(cliConnection, c.ui, brokerCredentials).Push(args[1:])
+		return push.NewPushCommand(c.cliConnection, c.ui, c.credentials).Push(args, parsed.push)
 	case "add-catalog":
-		if argLength == 3 {
-			assert.Condition(found, "target not set")
-			catalog.NewAddCatalogCommand(cliConnection, c.ui).AddCatalog(brokerCredentials, args[2])
-		} else if argLength == 6 {
-			brokerCredentials = broker.NewBrokerCredentials(args[2], args[3], args[4])
-			catalog.NewAddCatalogCommand(cliConnection, c.ui).AddCatalog(brokerCredentials, args[5])
-		} else {
-			assert.Condition(false, "incorrect number of arguments")
-		}
-		defer fmt.Println("Catalog item sucessfully added.")
+		if err := flagSet.RequireArgs("CATALOG"); err != nil {
+			return err
+		}
+		err := catalog.NewAddCatalogCommand(c.cliConnection, c.ui).AddCatalog(c.credentials, args[0])
+		if err == nil {
+			fmt.Println("Catalog item sucessfully added.")
+		}
+		return err
 	case "delete-catalog":
-		if argLength == 4 {
-			assert.Condition(found, "target not set")
-			catalog.NewAddCatalogCommand(cliConnection, c.ui).DeleteCatalog(brokerCredentials, args[2], args[3])
-		} else if argLength == 7 {
-			brokerCredentials = broker.NewBrokerCredentials(args[2], args[3], args[4])
-			catalog.NewAddCatalogCommand(cliConnection, c.ui).DeleteCatalog(brokerCredentials, args[5], args[6])
-		} else {
-			assert.Condition(false, "incorrect number of arguments")
+		if err := flagSet.RequireArgs("SERVICE", "VERSION"); err != nil {
+			return err
 		}
+		return catalog.NewAddCatalogCommand(c.cliConnection, c.ui).DeleteCatalog(c.credentials, args[0], args[1])
 	case "effectors":
-		if argLength == 3 {
-			assert.Condition(found, "target not set")
-			effectors.NewEffectorCommand(cliConnection, c.ui).ListEffectors(brokerCredentials, args[2])
-		} else if argLength == 6 {
-			brokerCredentials = broker.NewBrokerCredentials(args[2], args[3], args[4])
-			effectors.NewEffectorCommand(cliConnection, c.ui).ListEffectors(brokerCredentials, args[5])
-		} else {
-			assert.Condition(false, "incorrect number of arguments")
+		if err := flagSet.RequireArgs("SERVICE"); err != nil {
+			return err
+		}
+		options := effectors.ListOptions{
+			Output: parsed.output,
+			Tree:   parsed.tree,
 		}
+		return effectors.NewEffectorCommand(c.cliConnection, c.ui).ListEffectors(c.credentials, args[0], options)
 	case "invoke":
-		// TODO need to take a flag to specify broker creds
-		// if args[2] == -b then args[2:4] are broker creds
-		// so that command can be run without specifying
-		// broker credentials
-		if argLength >= 7 {
-			brokerCredentials = broker.NewBrokerCredentials(args[2], args[3], args[4])
-			effectors.NewEffectorCommand(cliConnection, c.ui).InvokeEffector(brokerCredentials, args[5], args[6], args[7:])
-		} else {
-			assert.Condition(false, "incorrect number of arguments")
+		if err := flagSet.RequireArgsAtLeast("SERVICE"); err != nil {
+			return err
 		}
+		// without an effector, the user is asked to pick one
+		effector, params := "", args[1:]
+		if len(params) > 0 && !strings.HasPrefix(params[0], "-") {
+			effector, params = params[0], params[1:]
+		}
+		return effectors.NewEffectorCommand(c.cliConnection, c.ui).InvokeEffector(c.credentials, args[0], effector, params, parsed.invoke)
+	case "task":
+		if err := flagSet.RequireArgs("SERVICE", "TASK_ID"); err != nil {
+			return err
+		}
+		return effectors.NewEffectorCommand(c.cliConnection, c.ui).ShowTask(c.credentials, args[0], args[1], parsed.output)
 	case "sensors":
-		if argLength == 3 {
-			assert.Condition(found, "target not set")
-			sensors.NewSensorCommand(cliConnection, c.ui).ListSensors(brokerCredentials, args[2])
-		} else if argLength == 6 {
-			brokerCredentials = broker.NewBrokerCredentials(args[2], args[3], args[4])
-			sensors.NewSensorCommand(cliConnection, c.ui).ListSensors(brokerCredentials, args[5])
-		} else {
-			assert.Condition(false, "incorrect number of arguments")
+		if err := flagSet.RequireArgs("SERVICE"); err != nil {
+			return err
+		}
+		options := sensors.ListOptions{
+			Output: parsed.output,
+			Filter: parsed.sensorFilter,
+			Tree:   parsed.tree,
+		}
+		if parsed.watch {
+			return sensors.NewSensorCommand(c.cliConnection, c.ui).WatchSensors(c.credentials, args[0], options, parsed.interval)
 		}
+		return sensors.NewSensorCommand(c.cliConnection, c.ui).ListSensors(c.credentials, args[0], options)
+	case "sensor":
+		if err := flagSet.RequireArgs("SERVICE", "ENTITY", "SENSOR"); err != nil {
+			return err
+		}
+		return sensors.NewSensorCommand(c.cliConnection, c.ui).PrintSensor(c.credentials, args[0], args[1], args[2])
 	case "ready":
-		if argLength == 3 {
-			assert.Condition(found, "target not set")
-			fmt.Println("Ready:", sensors.NewSensorCommand(cliConnection, c.ui).IsServiceReady(brokerCredentials, args[2]))
-		} else if argLength == 6 {
-			brokerCredentials = broker.NewBrokerCredentials(args[2], args[3], args[4])
-			fmt.Println("Ready:", sensors.NewSensorCommand(cliConnection, c.ui).IsServiceReady(brokerCredentials, args[5]))
-		} else {
-			assert.Condition(false, "incorrect number of arguments")
+		if err := flagSet.RequireArgs("SERVICE"); err != nil {
+			return err
+		}
+		ready, err := sensors.NewSensorCommand(c.cliConnection, c.ui).IsServiceReady(c.credentials, args[0])
+		if err == nil {
+			fmt.Println("Ready:", ready)
 		}
+		return err
 	}
-	fmt.Println(terminal.ColorizeBold("OK", 32))
+	return nil
+}
 
+// brokerCredentials returns the credentials of the stored login target,
+// or of the broker named by --broker, with --username and --password
+// taking precedence over stored values.
+func (c *BrooklynPlugin) brokerCredentials(override brokerFlags) (*broker.BrokerCredentials, error) {
+	target := firstNonEmpty(override.broker, os.Getenv(credentials.BrokerEnv), c.store.Target())
+	if target == "" {
+		return nil, failure.NewUsageError("target not set, use cf brooklyn login or --broker")
+	}
+	username, password := override.username, override.password
+	if username == "" {
+		username = c.store.Username(target)
+	}
+	if password == "" {
+		var err error
+		if password, err = c.store.Password(target); err != nil {
+			return nil, err
+		}
+	}
+	if username == "" || password == "" {
+		return nil, failure.NewUsageError("no credentials for broker %s, use cf brooklyn login or --username and --password", target)
+	}
+	return broker.NewBrokerCredentials(target, username, password), nil
 }
 
 func (c *BrooklynPlugin) GetMetadata() plugin.PluginMetadata {
@@ -190,7 +439,34 @@ func (c *BrooklynPlugin) GetMetadata() plugin.PluginMetadata {
 				Name:     "brooklyn login",
 				HelpText: "Store Broker login credentials for use between commands",
 				UsageDetails: plugin.Usage{
-					Usage: "cf brooklyn login",
+					Usage: "cf brooklyn login [--force] [--broker BROKER] [--username USERNAME] [--password-stdin]",
+					Options: map[string]string{
+						"force":          "Ask for the username and password even if already stored",
+						"broker":         "Broker to log in to, instead of asking or reading " + credentials.BrokerEnv,
+						"username":       "Username, instead of asking or reading " + credentials.UsernameEnv,
+						"password-stdin": "Read the password from standard input, instead of asking or reading " + credentials.PasswordEnv,
+					},
+				},
+			},
+			{
+				Name:     "brooklyn logout",
+				HelpText: "Remove the stored credentials of a broker, by default the current target",
+				UsageDetails: plugin.Usage{
+					Usage: "cf brooklyn logout [BROKER]",
+				},
+			},
+			{
+				Name:     "brooklyn targets",
+				HelpText: "List the brokers with stored credentials",
+				UsageDetails: plugin.Usage{
+					Usage: "cf brooklyn targets",
+				},
+			},
+			{
+				Name:     "brooklyn target",
+				HelpText: "Show the current target broker or switch to another stored one",
+				UsageDetails: plugin.Usage{
+					Usage: "cf brooklyn target [BROKER]",
 				},
 			},
 			{
@@ -198,7 +474,17 @@ func (c *BrooklynPlugin) GetMetadata() plugin.PluginMetadata {
 				HelpText: "Push a new app, replacing " +
 					"brooklyn section with instantiated services",
 				UsageDetails: plugin.Usage{
-					Usage: "cf brooklyn push [-f MANIFEST]",
+					Usage: "cf brooklyn push [-f MANIFEST] [--dry-run] [--keep-services] [--parallel N] " +
+						"[--timeout DURATION] [--poll-interval DURATION] [--max-interval DURATION] [PUSH OPTIONS...]",
+					Options: withBrokerOptions(map[string]string{
+						"f":             "Path to manifest, defaults to manifest.yml",
+						"dry-run":       "Show the catalog items and services that would be created and the manifest for cf push, without changing anything",
+						"keep-services": "Reuse existing services whose blueprint has changed since they were created, which still run the earlier version",
+						"parallel":      "How many services to create, and then check on while waiting for them to start, at once, defaults to 4",
+						"timeout":       "Give up if the services are not running after this long, such as 20m; by default push waits for as long as it takes",
+						"poll-interval": "Time before checking on the services again, doubling after each check, defaults to 2s",
+						"max-interval":  "Longest time between checks on the services, defaults to 1m",
+					}),
 				},
 			},
 			{
@@ -206,35 +492,85 @@ func (c *BrooklynPlugin) GetMetadata() plugin.PluginMetadata {
 				HelpText: "Submit a Blueprint to Brooklyn to be " +
 					"added to its catalog",
 				UsageDetails: plugin.Usage{
-					Usage: "cf brooklyn add-catalog CATALOG",
+					Usage:   "cf brooklyn add-catalog CATALOG",
+					Options: withBrokerOptions(nil),
 				},
 			},
 			{
 				Name:     "brooklyn delete-catalog",
 				HelpText: "Delete an item from the Brooklyn catalog",
 				UsageDetails: plugin.Usage{
-					Usage: "cf brooklyn delete-catalog SERVICE VERSION",
+					Usage:   "cf brooklyn delete-catalog SERVICE VERSION",
+					Options: withBrokerOptions(nil),
 				},
 			},
 			{
 				Name:     "brooklyn effectors",
 				HelpText: "List the effectors available to a service",
 				UsageDetails: plugin.Usage{
-					Usage: "cf brooklyn effectors [BROKER USERNAME PASSWORD] SERVICE",
+					Usage: "cf brooklyn effectors SERVICE [--output json|yaml | --json | --no-color] [--depth N]",
+					Options: withBrokerOptions(treeOptions, map[string]string{
+						"output": "Print the effectors and their parameters as json or yaml rather than as a tree",
+						"json":   "Same as --output json",
+					}),
 				},
 			},
 			{
 				Name:     "brooklyn invoke",
 				HelpText: "Invoke an effector on a service",
 				UsageDetails: plugin.Usage{
-					Usage: "cf brooklyn invoke [BROKER USERNAME PASSWORD] SERVICE EFFECTOR",
+					Usage: "cf brooklyn invoke SERVICE [ENTITY:EFFECTOR] [--wait | --async] [--params-file FILE] " +
+						"[--params JSON|-] [--param NAME=VALUE|NAME=@FILE...] [[--] --PARAMETER VALUE...]",
+					Options: withBrokerOptions(map[string]string{
+						"params-file": "Read a map of parameters from a JSON or YAML file",
+						"params":      "Take a map of parameters as JSON, or read it from standard input if -",
+						"param":       "Give a parameter, reading its value from FILE if written as @FILE; may be repeated",
+						"wait":        "Wait for the effector to finish, showing its progress and result",
+						"async":       "Return as soon as the effector has started, printing the id of its task",
+					}),
+				},
+			},
+			{
+				Name:     "brooklyn task",
+				HelpText: "Show the status and result of a task, such as an effector invoked with --async",
+				UsageDetails: plugin.Usage{
+					Usage: "cf brooklyn task SERVICE TASK_ID [--output json|yaml | --json]",
+					Options: withBrokerOptions(map[string]string{
+						"output": "Print the task as json or yaml",
+						"json":   "Same as --output json",
+					}),
 				},
 			},
 			{
 				Name:     "brooklyn sensors",
 				HelpText: "List the sensors with their outputs for a service",
 				UsageDetails: plugin.Usage{
-					Usage: "cf brooklyn sensors [BROKER USERNAME PASSWORD] SERVICE",
+					Usage: "cf brooklyn sensors SERVICE [--entity PATH] [--sensor PATTERN] [--depth N] " +
+						"[--output json|yaml | --json | --watch [--interval DURATION]] [--no-color]",
+					Options: withBrokerOptions(treeOptions, map[string]string{
+						"output":   "Print the sensors as json or yaml rather than as a tree",
+						"json":     "Same as --output json",
+						"entity":   "Only show entities whose path, such as app/cluster/node-1, matches this glob, and their children",
+						"sensor":   "Only show sensors whose name matches this glob, or this regular expression if between slashes",
+						"watch":    "Keep refreshing the sensors, highlighting values that change, until Ctrl-C",
+						"interval": "Time between refreshes when watching, defaults to 5s",
+					}),
+				},
+			},
+			{
+				Name:     "brooklyn sensor",
+				HelpText: "Print the value of a single sensor of a service",
+				UsageDetails: plugin.Usage{
+					Usage:   "cf brooklyn sensor SERVICE ENTITY SENSOR",
+					Options: withBrokerOptions(nil),
+				},
+			},
+			{
+				Name:     "brooklyn ready",
+				HelpText: "Check whether a service is running and ready for binding",
+				UsageDetails: plugin.Usage{
+					Usage:   "cf brooklyn ready SERVICE",
+					Options: withBrokerOptions(nil),
 				},
 			},
 		},
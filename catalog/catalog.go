@@ -2,11 +2,9 @@ package catalog
 
 import (
 	"fmt"
-	"github.com/cloudfoundry-community/brooklyn-plugin/assert"
 	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
 	"github.com/cloudfoundry/cli/cf/terminal"
 	"github.com/cloudfoundry/cli/plugin"
-	"net/http"
 	"os"
 	"path/filepath"
 )
@@ -23,24 +21,19 @@ func NewAddCatalogCommand(cliConnection plugin.CliConnection, ui terminal.UI) *A
 	return command
 }
 
-func (c *AddCatalogCommand) AddCatalog(cred *broker.BrokerCredentials, filePath string) {
+func (c *AddCatalogCommand) AddCatalog(cred *broker.BrokerCredentials, filePath string) error {
 	fmt.Println("Adding Brooklyn catalog item...")
 
 	file, err := os.Open(filepath.Clean(filePath))
-	assert.ErrorIsNil(err)
+	if err != nil {
+		return err
+	}
 	defer file.Close()
 
-	req, err := http.NewRequest("POST", broker.CreateRestCallUrlString(c.cliConnection, cred, "create"), file)
-	assert.ErrorIsNil(err)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	broker.SendRequest(req)
+	return broker.NewClient(c.cliConnection, cred).CreateCatalogItem(file)
 }
 
-func (c *AddCatalogCommand) DeleteCatalog(cred *broker.BrokerCredentials, name, version string) {
+func (c *AddCatalogCommand) DeleteCatalog(cred *broker.BrokerCredentials, name, version string) error {
 	fmt.Println("Deleting Brooklyn catalog item...")
-	req, err := http.NewRequest("DELETE",
-		broker.CreateRestCallUrlString(c.cliConnection, cred, "delete/"+name+"/"+version+"/"),
-		nil)
-	assert.ErrorIsNil(err)
-	broker.SendRequest(req)
+	return broker.NewClient(c.cliConnection, cred).DeleteCatalogItem(name, version)
 }
@@ -0,0 +1,91 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/cloudfoundry/cli/plugin/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Scope identifies the Cloud Foundry API endpoint, org and space that
+// stored credentials belong to, so that brokers of the same name on
+// different foundations do not collide.
+type Scope struct {
+	ApiEndpoint string
+	Org         string
+	Space       string
+}
+
+func (s Scope) String() string {
+	return fmt.Sprintf("%s %s/%s", s.ApiEndpoint, s.Org, s.Space)
+}
+
+// targetConnection is implemented by the CLI connection of cf versions
+// whose plugin API can report the target.
+type targetConnection interface {
+	ApiEndpoint() (string, error)
+	GetCurrentOrg() (plugin_models.Organization, error)
+	GetCurrentSpace() (plugin_models.Space, error)
+}
+
+// cfConfig holds the parts of the cf CLI configuration that say what is
+// targeted.
+type cfConfig struct {
+	Target             string
+	OrganizationFields struct{ Name string }
+	SpaceFields        struct{ Name string }
+}
+
+// CurrentScope returns the scope of whatever cf target points to, asking
+// the CLI connection if it can say.
+func CurrentScope(cliConnection plugin.CliConnection) (Scope, error) {
+	connection, found := cliConnection.(targetConnection)
+	if !found {
+		return configuredScope()
+	}
+	endpoint, err := connection.ApiEndpoint()
+	if err != nil {
+		return Scope{}, fmt.Errorf("could not get the current cf target, use cf login: %s", err)
+	}
+	if endpoint == "" {
+		return Scope{}, errors.New("no API endpoint targeted, use cf login")
+	}
+	// no org or space may be targeted yet, which leaves them empty
+	org, _ := connection.GetCurrentOrg()
+	space, _ := connection.GetCurrentSpace()
+	return Scope{endpoint, org.Name, space.Name}, nil
+}
+
+// configuredScope reads the scope from the configuration the cf CLI
+// keeps, for versions of cf whose plugin API cannot report the target.
+// It is read rather than the output of cf target, which changes with
+// the language and version of the CLI.
+func configuredScope() (Scope, error) {
+	path := cfConfigPath()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Scope{}, fmt.Errorf("could not read the current cf target from %s, use cf login: %s", path, err)
+	}
+	var config cfConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Scope{}, fmt.Errorf("could not read the current cf target from %s: %s", path, err)
+	}
+	if config.Target == "" {
+		return Scope{}, errors.New("no API endpoint targeted, use cf login")
+	}
+	return Scope{config.Target, config.OrganizationFields.Name, config.SpaceFields.Name}, nil
+}
+
+// cfConfigPath returns where the cf CLI keeps its configuration, under
+// CF_HOME if it is set or else the home directory.
+func cfConfigPath() string {
+	home := os.Getenv("CF_HOME")
+	if home == "" {
+		home = os.Getenv("HOME")
+	}
+	return filepath.Join(home, ".cf", "config.json")
+}
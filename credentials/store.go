@@ -0,0 +1,278 @@
+package credentials
+
+import (
+	"fmt"
+	"github.com/cloudfoundry-community/brooklyn-plugin/io"
+	"github.com/cloudfoundry/cli/generic"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Environment variables that, when set, take precedence over the
+// stored credentials or supply the passphrase protecting them.
+const (
+	BrokerEnv     = "CF_BROOKLYN_BROKER"
+	UsernameEnv   = "CF_BROOKLYN_USERNAME"
+	PasswordEnv   = "CF_BROOKLYN_PASSWORD"
+	PassphraseEnv = "CF_BROOKLYN_PASSPHRASE"
+)
+
+const filePerm = 0600
+
+// Store holds the broker credentials kept between commands, for each
+// Cloud Foundry API endpoint, org and space. Passwords are encrypted
+// with a key derived from a passphrase, which is read from
+// CF_BROOKLYN_PASSPHRASE or asked for when first needed.
+//
+// The file is laid out as
+//
+//	foundations:
+//	  https://api.example.com:
+//	    my-org/my-space:
+//	      target: brooklyn
+//	      auth:
+//	        brooklyn:
+//	          username: admin
+//	          encrypted-password: ...
+type Store struct {
+	path          string
+	yamlMap       generic.Map
+	scope         Scope
+	askPassphrase func() string
+	passphrase    string
+}
+
+func DefaultPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".cf_brooklyn_plugin")
+}
+
+// Load reads the store at path, creating it if it does not exist, and
+// selects the credentials of the given scope. Credentials saved by
+// earlier versions, which were not scoped and held passwords in plain
+// text, are moved into the scope and encrypted.
+func Load(path string, scope Scope, askPassphrase func() string) (*Store, error) {
+	store := new(Store)
+	store.path = path
+	store.scope = scope
+	store.askPassphrase = askPassphrase
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		store.yamlMap = generic.NewMap()
+		return store, store.Save()
+	}
+	yamlMap, err := io.ReadYAMLFile(path)
+	if err != nil {
+		return nil, err
+	}
+	store.yamlMap = yamlMap
+	return store, store.migrate()
+}
+
+func (s *Store) Scope() Scope {
+	return s.scope
+}
+
+func (s *Store) Target() string {
+	target, _ := s.scoped().Get("target").(string)
+	return target
+}
+
+func (s *Store) SetTarget(broker string) {
+	s.scoped().Set("target", broker)
+}
+
+func (s *Store) ClearTarget() {
+	s.scoped().Delete("target")
+}
+
+// Has reports whether credentials are stored for the broker.
+func (s *Store) Has(broker string) bool {
+	return s.entry(broker) != nil
+}
+
+// Brokers returns the names of the brokers with stored credentials,
+// sorted.
+func (s *Store) Brokers() []string {
+	var brokers []string
+	s.auth().Each(func(broker, entry interface{}) {
+		brokers = append(brokers, broker.(string))
+	})
+	sort.Strings(brokers)
+	return brokers
+}
+
+// Remove deletes the credentials of the broker, clearing the target
+// if it was that broker.
+func (s *Store) Remove(broker string) {
+	s.auth().Delete(broker)
+	if s.Target() == broker {
+		s.ClearTarget()
+	}
+}
+
+// Set stores the credentials of a broker, encrypting the password.
+func (s *Store) Set(broker, username, password string) error {
+	passphrase, err := s.unlock()
+	if err != nil {
+		return err
+	}
+	encrypted, err := encrypt(passphrase, password)
+	if err != nil {
+		return err
+	}
+	s.auth().Set(broker, generic.NewMap(map[string]string{
+		"username":           username,
+		"encrypted-password": encrypted,
+	}))
+	return nil
+}
+
+// Username returns the username for the broker, from UsernameEnv if
+// set, or else from the store.
+func (s *Store) Username(broker string) string {
+	if username := os.Getenv(UsernameEnv); username != "" {
+		return username
+	}
+	if entry := s.entry(broker); entry != nil {
+		username, _ := entry.Get("username").(string)
+		return username
+	}
+	return ""
+}
+
+// Password returns the password for the broker, from PasswordEnv if
+// set, or else by decrypting the stored one.
+func (s *Store) Password(broker string) (string, error) {
+	if password := os.Getenv(PasswordEnv); password != "" {
+		return password, nil
+	}
+	entry := s.entry(broker)
+	if entry == nil {
+		return "", nil
+	}
+	encrypted, found := entry.Get("encrypted-password").(string)
+	if !found {
+		password, _ := entry.Get("password").(string)
+		return password, nil
+	}
+	passphrase, err := s.unlock()
+	if err != nil {
+		return "", err
+	}
+	password, err := decrypt(passphrase, encrypted)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt password for broker %s: %s", broker, err)
+	}
+	return password, nil
+}
+
+// Save writes the store, readable only by its owner.
+func (s *Store) Save() error {
+	return io.WriteYAMLFileWithPerm(s.yamlMap, s.path, filePerm)
+}
+
+// migrate moves unscoped credentials into the current scope and
+// encrypts passwords stored in plain text.
+func (s *Store) migrate() error {
+	migrated := false
+	if s.yamlMap.Has("auth") || s.yamlMap.Has("target") {
+		fmt.Fprintln(os.Stderr, "Moving stored broker credentials to Cloud Foundry target", s.scope)
+		scoped := s.scoped()
+		for _, key := range []string{"auth", "target"} {
+			if s.yamlMap.Has(key) && !scoped.Has(key) {
+				scoped.Set(key, s.yamlMap.Get(key))
+			}
+			s.yamlMap.Delete(key)
+		}
+		migrated = true
+	}
+
+	var plaintext []interface{}
+	s.auth().Each(func(broker, entry interface{}) {
+		if generic.NewMap(entry).Has("password") {
+			plaintext = append(plaintext, broker)
+		}
+	})
+	if len(plaintext) > 0 {
+		fmt.Fprintln(os.Stderr, "Encrypting stored broker passwords.")
+	}
+	for _, broker := range plaintext {
+		entry := generic.NewMap(s.auth().Get(broker))
+		username, _ := entry.Get("username").(string)
+		password, _ := entry.Get("password").(string)
+		if err := s.Set(broker.(string), username, password); err != nil {
+			return err
+		}
+		migrated = true
+	}
+
+	if !migrated {
+		return nil
+	}
+	return s.Save()
+}
+
+// unlock returns the passphrase, asking for it if necessary, and checks
+// it against any password already encrypted in the store, whatever its
+// scope, so that one passphrase protects them all.
+func (s *Store) unlock() (string, error) {
+	if s.passphrase != "" {
+		return s.passphrase, nil
+	}
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		passphrase = s.askPassphrase()
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("a passphrase is needed to protect stored passwords, set %s or enter one when asked", PassphraseEnv)
+	}
+	if check := s.anyEncryptedPassword(); check != "" {
+		if _, err := decrypt(passphrase, check); err != nil {
+			return "", err
+		}
+	}
+	s.passphrase = passphrase
+	return passphrase, nil
+}
+
+func (s *Store) anyEncryptedPassword() string {
+	var check string
+	child(s.yamlMap, "foundations").Each(func(_, foundation interface{}) {
+		generic.NewMap(foundation).Each(func(_, space interface{}) {
+			child(generic.NewMap(space), "auth").Each(func(_, entry interface{}) {
+				if encrypted, found := generic.NewMap(entry).Get("encrypted-password").(string); found {
+					check = encrypted
+				}
+			})
+		})
+	})
+	return check
+}
+
+// scoped returns the part of the store belonging to the current scope.
+func (s *Store) scoped() generic.Map {
+	foundation := child(child(s.yamlMap, "foundations"), s.scope.ApiEndpoint)
+	return child(foundation, s.scope.Org+"/"+s.scope.Space)
+}
+
+func (s *Store) auth() generic.Map {
+	return child(s.scoped(), "auth")
+}
+
+func (s *Store) entry(broker string) generic.Map {
+	auth := s.auth()
+	if !auth.Has(broker) {
+		return nil
+	}
+	return generic.NewMap(auth.Get(broker))
+}
+
+// child returns the map held under key, adding an empty one if there
+// is none.
+func child(parent generic.Map, key string) generic.Map {
+	if !parent.Has(key) {
+		parent.Set(key, generic.NewMap())
+	}
+	return generic.NewMap(parent.Get(key))
+}
@@ -0,0 +1,137 @@
+package effectors
+
+import (
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"strings"
+)
+
+// entityIdSensor is published by every Brooklyn entity with the id
+// Brooklyn gave it.
+const entityIdSensor = "entity.id"
+
+// splitAddress splits ENTITY:EFFECTOR at the last colon, as effector
+// names cannot contain one but entity names can.
+func splitAddress(address string) (entity, effector string, err error) {
+	colon := strings.LastIndex(address, ":")
+	if colon <= 0 || colon == len(address)-1 {
+		return "", "", failure.NewUsageError("invalid effector format %q, expected ENTITY:EFFECTOR", address)
+	}
+	return address[:colon], address[colon+1:], nil
+}
+
+// idLookup returns the ids of the entities of a service instance, keyed
+// by their path in the tree.
+type idLookup func() (map[string]string, error)
+
+// entityIds returns an idLookup that reads the ids from the entity.id
+// sensors of the service instance, fetching them the first time only.
+func entityIds(client *broker.Client, guid string) idLookup {
+	var ids map[string]string
+	return func() (map[string]string, error) {
+		if ids != nil {
+			return ids, nil
+		}
+		sensors, err := client.Sensors(guid)
+		if err != nil {
+			return nil, err
+		}
+		ids = make(map[string]string)
+		sensors.Walk(func(path []string, entity *broker.EntitySensors) {
+			if id, _ := entity.Sensors[entityIdSensor].(string); id != "" {
+				ids[strings.Join(path, "/")] = id
+			}
+		})
+		return ids, nil
+	}
+}
+
+// entityIndex finds the entities of the effector tree by path, name or
+// id, and works out how to address them to the broker.
+type entityIndex struct {
+	// entities and names are keyed by path, such as app/cluster/node
+	entities map[string]*broker.EntityEffectors
+	names    map[string]string
+	// paths holds the paths of the entities with each name
+	paths map[string][]string
+	ids   idLookup
+}
+
+func newEntityIndex(effectors *broker.EffectorTree, ids idLookup) *entityIndex {
+	index := &entityIndex{
+		entities: make(map[string]*broker.EntityEffectors),
+		names:    make(map[string]string),
+		paths:    make(map[string][]string),
+		ids:      ids,
+	}
+	effectors.Walk(func(path []string, entity *broker.EntityEffectors) {
+		key, name := strings.Join(path, "/"), path[len(path)-1]
+		index.entities[key] = entity
+		index.names[key] = name
+		index.paths[name] = append(index.paths[name], key)
+	})
+	return index
+}
+
+// find returns the path of the entity given by its path, its name if no
+// other entity shares it, or its id.
+func (x *entityIndex) find(entity string) (string, error) {
+	if _, found := x.entities[entity]; found {
+		return entity, nil
+	}
+	switch paths := x.paths[entity]; len(paths) {
+	case 0:
+	case 1:
+		return paths[0], nil
+	default:
+		return "", failure.NewUsageError("entities %s are all named %s, give the path or id of one",
+			strings.Join(paths, ", "), entity)
+	}
+	ids, err := x.ids()
+	if err != nil {
+		return "", err
+	}
+	for path, id := range ids {
+		if _, found := x.entities[path]; found && id == entity {
+			return path, nil
+		}
+	}
+	return "", failure.NewUsageError("no entity %s, see cf brooklyn effectors for those available", entity)
+}
+
+// target returns how the broker addresses the entity at path: by its
+// name, or by its id when other entities share the name.
+func (x *entityIndex) target(path string) (string, error) {
+	name := x.names[path]
+	if len(x.paths[name]) == 1 {
+		return name, nil
+	}
+	ids, err := x.ids()
+	if err != nil {
+		return "", err
+	}
+	if id := ids[path]; id != "" {
+		return id, nil
+	}
+	return "", failure.NewUsageError("entities %s are all named %s, and %s publishes no %s sensor to tell it apart",
+		strings.Join(x.paths[name], ", "), name, path, entityIdSensor)
+}
+
+// resolveEffector finds the effector of the entity given by its path in
+// the tree, such as app/cluster/member-2, its name or its id, returning
+// the entity as the broker addresses it along with the effector.
+func resolveEffector(x *entityIndex, entity, name string) (string, *broker.Effector, error) {
+	path, err := x.find(entity)
+	if err != nil {
+		return "", nil, err
+	}
+	effector := x.entities[path].Effectors[name]
+	if effector == nil {
+		return "", nil, failure.NewUsageError("entity %s has no effector %s, see cf brooklyn effectors for those available", entity, name)
+	}
+	target, err := x.target(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return target, effector, nil
+}
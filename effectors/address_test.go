@@ -0,0 +1,87 @@
+package effectors
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"testing"
+)
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		address, entity, effector string
+	}{
+		{"app:restart", "app", "restart"},
+		{"app/cluster/node:restart", "app/cluster/node", "restart"},
+		{"node:8080:restart", "node:8080", "restart"},
+		{"web cluster:resize", "web cluster", "resize"},
+	}
+	for _, test := range tests {
+		entity, effector, err := splitAddress(test.address)
+		if err != nil || entity != test.entity || effector != test.effector {
+			t.Errorf("splitAddress(%q) = %q, %q, %v, want %q, %q", test.address, entity, effector, err, test.entity, test.effector)
+		}
+	}
+	for _, address := range []string{"restart", ":restart", "app:", ""} {
+		if _, _, err := splitAddress(address); !failure.IsUsageError(err) {
+			t.Errorf("splitAddress(%q) returned %v, want a usage error", address, err)
+		}
+	}
+}
+
+// testEffectors lists the children of the application beside it, as the
+// broker does.
+const testEffectors = `{
+	"app": {
+		"restart": {"description": "restart", "parameters": []}
+	},
+	"children": {
+		"cluster": {
+			"resize": {"description": "resize", "parameters": []},
+			"children": {
+				"node": {"stop": {"description": "stop", "parameters": []}}
+			}
+		},
+		"db": {
+			"children": {
+				"node": {"stop": {"description": "stop", "parameters": []}}
+			}
+		}
+	}
+}`
+
+func TestResolveEffector(t *testing.T) {
+	var effectors broker.EffectorTree
+	if err := json.Unmarshal([]byte(testEffectors), &effectors); err != nil {
+		t.Fatal(err)
+	}
+	ids := func() (map[string]string, error) {
+		return map[string]string{"app/cluster/node": "a1b2c3d4", "app/db/node": "e5f6g7h8"}, nil
+	}
+	index := newEntityIndex(&effectors, ids)
+	tests := []struct {
+		entity, effector, target string
+	}{
+		{"app", "restart", "app"},
+		{"cluster", "resize", "cluster"},
+		{"app/cluster", "resize", "cluster"},
+		{"app/cluster/node", "stop", "a1b2c3d4"},
+		{"app/db/node", "stop", "e5f6g7h8"},
+		{"e5f6g7h8", "stop", "e5f6g7h8"},
+	}
+	for _, test := range tests {
+		target, effector, err := resolveEffector(index, test.entity, test.effector)
+		if err != nil {
+			t.Errorf("resolveEffector(%q, %q) failed: %s", test.entity, test.effector, err)
+			continue
+		}
+		if target != test.target || effector.Description != test.effector {
+			t.Errorf("resolveEffector(%q, %q) = %q, %s, want %q", test.entity, test.effector, target, effector.Description, test.target)
+		}
+	}
+	for _, address := range [][2]string{{"node", "stop"}, {"nothing", "stop"}, {"app", "stop"}} {
+		if _, _, err := resolveEffector(index, address[0], address[1]); !failure.IsUsageError(err) {
+			t.Errorf("resolveEffector(%q, %q) returned %v, want a usage error", address[0], address[1], err)
+		}
+	}
+}
@@ -1,14 +1,16 @@
 package effectors
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/cloudfoundry-community/brooklyn-plugin/assert"
 	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"github.com/cloudfoundry-community/brooklyn-plugin/output"
+	"github.com/cloudfoundry-community/brooklyn-plugin/tree"
 	"github.com/cloudfoundry/cli/cf/terminal"
 	"github.com/cloudfoundry/cli/plugin"
-	"net/http"
+	"os"
+	"sort"
 	"strings"
 )
 
@@ -24,105 +26,193 @@ func NewEffectorCommand(cliConnection plugin.CliConnection, ui terminal.UI) *Eff
 	return command
 }
 
-func (c *EffectorCommand) InvokeEffector(cred *broker.BrokerCredentials, service, effector string, params []string) {
-	guid, err := c.cliConnection.CliCommandWithoutTerminalOutput("service", service, "--guid")
-	assert.ErrorIsNil(err)
-	assert.Condition(strings.Contains(effector, ":"), "invalid effector format")
-	split := strings.Split(effector, ":")
-	path := "invoke/" + guid[0] + "/" + split[0] + "/" + split[1]
-	fmt.Println("Invoking effector", terminal.ColorizeBold(effector, 36))
-
-	m := make(map[string]string)
-	for i := 0; i < len(params); i = i + 2 {
-		assert.Condition(strings.HasPrefix(params[i], "--"), "invalid parameter format")
-		k := strings.TrimPrefix(params[i], "--")
-		v := params[i+1]
+// InvokeEffector invokes an effector given as ENTITY:EFFECTOR, or if
+// none is given, asks which effector to invoke and for its parameters.
+func (c *EffectorCommand) InvokeEffector(cred *broker.BrokerCredentials, service, effector string, params []string, options InvokeOptions) error {
+	var entity, name string
+	if effector != "" {
+		var err error
+		if entity, name, err = splitAddress(effector); err != nil {
+			return err
+		}
+	}
+	if options.Wait && options.Async {
+		return failure.NewUsageError("--wait and --async cannot be used together")
+	}
+	given, err := gatherParams(options, params)
+	if err != nil {
+		return err
+	}
 
-		m[k] = v
-	}
-	post, err := json.Marshal(m)
-	assert.ErrorIsNil(err)
-	req, err := http.NewRequest("POST", broker.CreateRestCallUrlString(c.cliConnection, cred, path), bytes.NewBuffer(post))
-	req.Header.Set("Content-Type", "application/json")
-	assert.ErrorIsNil(err)
-	body, _ := broker.SendRequest(req)
-	fmt.Println(string(body))
+	guid, err := broker.ServiceGuid(c.cliConnection, service)
+	if err != nil {
+		return err
+	}
+	client := broker.NewClient(c.cliConnection, cred)
+	effectors, err := client.Effectors(guid)
+	if err != nil {
+		return err
+	}
+	index := newEntityIndex(effectors, entityIds(client, guid))
+	if effector == "" {
+		picked, err := c.pickEffector(service, effectors, index)
+		if err != nil {
+			return err
+		}
+		entity, name = picked.Entity, picked.Name
+	}
+	target, found, err := resolveEffector(index, entity, name)
+	if err != nil {
+		return err
+	}
+	if effector == "" {
+		if given, err = c.askParams(found, given); err != nil {
+			return err
+		}
+	}
+	m, err := prepareParams(found, given)
+	if err != nil {
+		return err
+	}
+	if options.Async {
+		// without tasks the effector would run to completion, which is
+		// what --async is there to avoid
+		supported, err := client.SupportsTasks(guid)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			return &broker.TasksUnsupportedError{}
+		}
+	}
+	fmt.Println("Invoking effector", terminal.ColorizeBold(entity+":"+name, 36))
+	if options.Wait || options.Async {
+		task, err := client.InvokeAsync(guid, target, name, m)
+		var unsupported *broker.TasksUnsupportedError
+		if options.Wait && errors.As(err, &unsupported) {
+			// the broker ran the effector to completion, as --wait asks
+			fmt.Println(unsupported.Result)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if options.Wait {
+			return waitForTask(client, guid, task)
+		}
+		fmt.Println("Started task", terminal.ColorizeBold(task.Id, 36))
+		fmt.Printf("See how it is doing with cf brooklyn task %s %s\n", service, task.Id)
+		return nil
+	}
+	result, err := client.Invoke(guid, target, name, m)
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
 }
 
-func (c *EffectorCommand) ListEffectors(cred *broker.BrokerCredentials, service string) {
-	guid, err := c.cliConnection.CliCommandWithoutTerminalOutput("service", service, "--guid")
-	url := broker.CreateRestCallUrlString(c.cliConnection, cred, "effectors/"+guid[0])
-	req, err := http.NewRequest("GET", url, nil)
-	assert.ErrorIsNil(err)
-	body, _ := broker.SendRequest(req)
-	//fmt.Println(string(body))
-	var effectors map[string]interface{}
-	err = json.Unmarshal(body, &effectors)
-	assert.ErrorIsNil(err)
-	fmt.Println(terminal.ColorizeBold(service, 32))
-	for i := 0; i < len(service); i++ {
-		fmt.Print(terminal.ColorizeBold("-", 32))
-	}
-	fmt.Println()
-	c.outputChildren(0, effectors)
+// ListOptions control how ListEffectors presents the effectors.
+type ListOptions struct {
+	// Output is the format to print the effectors in, by default a tree.
+	Output string
+	// Tree controls how the tree is rendered.
+	Tree tree.Options
+}
 
+// entityEffector is an effector together with the path of the entity
+// it can be invoked on.
+type entityEffector struct {
+	Entity      string                      `json:"entity"`
+	Name        string                      `json:"effector"`
+	Description string                      `json:"description"`
+	Parameters  []*broker.EffectorParameter `json:"parameters"`
 }
 
-func (c *EffectorCommand) outputChildren(indent int, effectors map[string]interface{}) {
-	children := effectors["children"]
-	for k, v := range effectors {
-		if k != "children" {
-			c.printIndent(indent)
-			if indent == 0 {
-				fmt.Print(terminal.ColorizeBold("Application:", 32))
+// listEffectors flattens the tree into a list sorted by entity path and
+// effector name.
+func listEffectors(effectors *broker.EffectorTree) []*entityEffector {
+	list := []*entityEffector{}
+	effectors.Walk(func(path []string, entity *broker.EntityEffectors) {
+		for name, effector := range entity.Effectors {
+			parameters := effector.Parameters
+			if parameters == nil {
+				parameters = []*broker.EffectorParameter{}
 			}
-			fmt.Println(terminal.ColorizeBold(k, 32))
-			c.outputEffectors(indent+1, v.(map[string]interface{}))
+			list = append(list, &entityEffector{
+				Entity:      strings.Join(path, "/"),
+				Name:        name,
+				Description: effector.Description,
+				Parameters:  parameters,
+			})
 		}
-	}
-
-	if children != nil {
-		c.outputChildren(indent+1, children.(map[string]interface{}))
-	}
+	})
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Entity != list[j].Entity {
+			return list[i].Entity < list[j].Entity
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
 }
 
-func (c *EffectorCommand) outputEffectors(indent int, effectors map[string]interface{}) {
-	children := effectors["children"]
-	for k, v := range effectors {
-		if k != "children" {
-			c.printIndent(indent)
-			c.printEffectorDescription(indent, terminal.ColorizeBold(k, 31), v.(map[string]interface{}))
-		}
+func (c *EffectorCommand) ListEffectors(cred *broker.BrokerCredentials, service string, options ListOptions) error {
+	guid, err := broker.ServiceGuid(c.cliConnection, service)
+	if err != nil {
+		return err
+	}
+	effectors, err := broker.NewClient(c.cliConnection, cred).Effectors(guid)
+	if err != nil {
+		return err
 	}
-	if children != nil {
-		c.outputChildren(indent, children.(map[string]interface{}))
+	if output.IsMachineReadable(options.Output) {
+		return output.Write(os.Stdout, options.Output, listEffectors(effectors))
 	}
+	tree.Render(os.Stdout, service, c.effectorNodes(1, effectors, options.Tree), options.Tree)
+	return nil
 }
 
-func (c *EffectorCommand) printEffectorDescription(indent int, effectorName string, effector map[string]interface{}) {
-	params := effector["parameters"].([]interface{})
-
-	fmt.Printf("%-30s %s\n", effectorName, effector["description"].(string))
-
-	if len(params) != 0 {
-
-		c.printIndent(indent + 1)
-		fmt.Println("parameters: ")
-		for _, k := range params {
-			c.printParameterDescription(indent+1, k.(map[string]interface{}))
+// effectorNodes builds the tree of entities and their effectors, down
+// to the depth chosen in the options.
+func (c *EffectorCommand) effectorNodes(depth int, effectors *broker.EffectorTree, options tree.Options) []*tree.Node {
+	var nodes []*tree.Node
+	byName := make(map[string]*tree.Node)
+	for k, v := range effectors.Entities {
+		label := k
+		if depth == 1 {
+			label = "Application:" + k
+		}
+		node := tree.Branch(label, tree.Green)
+		for name, effector := range v.Effectors {
+			node.Children = append(node.Children, effectorNode(name, effector))
+		}
+		if v.Children != nil && options.ShowDepth(depth+1) {
+			node.Children = append(node.Children, c.effectorNodes(depth+1, v.Children, options)...)
 		}
+		nodes = append(nodes, node)
+		byName[k] = node
 	}
-
-}
-
-func (c *EffectorCommand) printParameterDescription(indent int, parameter map[string]interface{}) {
-
-	c.printIndent(indent)
-	fmt.Printf("%-17s %-s\n", parameter["name"].(string), parameter["description"].(string))
+	if effectors.Children != nil && options.ShowDepth(depth+1) {
+		children := c.effectorNodes(depth+1, effectors.Children, options)
+		if owner, found := effectors.ChildrenOwner(); found {
+			byName[owner].Children = append(byName[owner].Children, children...)
+		} else {
+			nodes = append(nodes, children...)
+		}
+	}
+	return nodes
 }
 
-func (c *EffectorCommand) printIndent(indent int) {
-	for i := 0; i < indent; i++ {
-		fmt.Print("  ")
+func effectorNode(name string, effector *broker.Effector) *tree.Node {
+	node := tree.Leaf(name, effector.Description)
+	node.Color = tree.Red
+	if len(effector.Parameters) != 0 {
+		parameters := tree.Branch("parameters:", 0)
+		parameters.Ordered = true
+		for _, parameter := range effector.Parameters {
+			parameters.Children = append(parameters.Children, tree.Leaf(parameter.Name, parameter.Description))
+		}
+		node.Children = []*tree.Node{parameters}
 	}
+	return node
 }
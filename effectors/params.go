@@ -0,0 +1,309 @@
+package effectors
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"github.com/cloudfoundry-community/brooklyn-plugin/io"
+	"github.com/cloudfoundry-incubator/candiedyaml"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// parseParams reads the parameters given on the command line as
+// --NAME VALUE or --NAME=VALUE.
+func parseParams(args []string) (map[string]string, error) {
+	params := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			return nil, failure.NewUsageError("invalid parameter format %q, expected --NAME VALUE", args[i])
+		}
+		k := strings.TrimPrefix(args[i], "--")
+		if eq := strings.Index(k, "="); eq >= 0 {
+			params[k[:eq]] = k[eq+1:]
+			continue
+		}
+		if i+1 == len(args) {
+			return nil, failure.NewUsageError("parameter %q needs a value", args[i])
+		}
+		i++
+		params[k] = args[i]
+	}
+	return params, nil
+}
+
+// prepareParams checks the given parameters against those the effector
+// declares, converting each value given as a string to the declared
+// type, checking those already typed, and filling in defaults.
+// Parameters without a default are required.
+func prepareParams(effector *broker.Effector, given map[string]interface{}) (map[string]interface{}, error) {
+	declared := make(map[string]*broker.EffectorParameter)
+	for _, parameter := range effector.Parameters {
+		declared[parameter.Name] = parameter
+	}
+	var unknown []string
+	for name := range given {
+		if _, found := declared[name]; !found {
+			unknown = append(unknown, "--"+name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, failure.NewUsageError("unknown parameter %s, expected one of %s",
+			strings.Join(unknown, ", "), parameterNames(effector))
+	}
+
+	params := make(map[string]interface{})
+	var missing []string
+	for _, parameter := range effector.Parameters {
+		value, found := given[parameter.Name]
+		if !found {
+			if parameter.DefaultValue == nil {
+				missing = append(missing, "--"+parameter.Name)
+			} else {
+				params[parameter.Name] = parameter.DefaultValue
+			}
+			continue
+		}
+		text, isString := value.(string)
+		if !isString {
+			if err := checkType(parameter, value); err != nil {
+				return nil, err
+			}
+			params[parameter.Name] = value
+			continue
+		}
+		coerced, err := coerce(parameter, text)
+		if err != nil {
+			return nil, err
+		}
+		params[parameter.Name] = coerced
+	}
+	if len(missing) > 0 {
+		return nil, failure.NewUsageError("missing required parameter %s", strings.Join(missing, ", "))
+	}
+	return params, nil
+}
+
+func parameterNames(effector *broker.Effector) string {
+	if len(effector.Parameters) == 0 {
+		return "none"
+	}
+	var names []string
+	for _, parameter := range effector.Parameters {
+		names = append(names, "--"+parameter.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// coerce converts a value given on the command line to the type of the
+// parameter, which is a Java type such as java.lang.Integer or
+// java.util.Map<String,Object>. Maps and lists are given as JSON.
+// Values of other types are passed on as strings. Trailing whitespace,
+// such as the newline ending a file given as @FILE, is ignored for
+// numbers and booleans.
+func coerce(parameter *broker.EffectorParameter, value string) (interface{}, error) {
+	typeName := baseType(parameter)
+
+	scalar := strings.TrimRightFunc(value, unicode.IsSpace)
+	var coerced interface{}
+	var err error
+	switch typeName {
+	case "int", "integer", "long", "short", "byte", "biginteger":
+		coerced, err = strconv.ParseInt(scalar, 10, 64)
+	case "double", "float", "number", "bigdecimal":
+		coerced, err = strconv.ParseFloat(scalar, 64)
+	case "boolean":
+		coerced, err = strconv.ParseBool(scalar)
+	case "map":
+		var m map[string]interface{}
+		err = json.Unmarshal([]byte(value), &m)
+		coerced = m
+	case "list", "collection", "set", "iterable":
+		var l []interface{}
+		err = json.Unmarshal([]byte(value), &l)
+		coerced = l
+	default:
+		return value, nil
+	}
+	if err != nil {
+		return nil, failure.NewUsageError("parameter --%s expects a %s, not %q", parameter.Name, describeType(typeName), value)
+	}
+	return coerced, nil
+}
+
+// checkType checks a value read from JSON or YAML, which is already
+// typed, against the type of the parameter.
+func checkType(parameter *broker.EffectorParameter, value interface{}) error {
+	typeName := baseType(parameter)
+	valid := true
+	switch typeName {
+	case "int", "integer", "long", "short", "byte", "biginteger":
+		switch v := value.(type) {
+		case int, int64, uint64:
+		case float64:
+			valid = v == math.Trunc(v)
+		default:
+			valid = false
+		}
+	case "double", "float", "number", "bigdecimal":
+		switch value.(type) {
+		case int, int64, uint64, float64:
+		default:
+			valid = false
+		}
+	case "boolean":
+		_, valid = value.(bool)
+	case "map":
+		_, valid = value.(map[string]interface{})
+	case "list", "collection", "set", "iterable":
+		_, valid = value.([]interface{})
+	}
+	if !valid {
+		return failure.NewUsageError("parameter --%s expects a %s, not %v", parameter.Name, describeType(typeName), value)
+	}
+	return nil
+}
+
+// baseType returns the lower case name of the parameter's Java type,
+// without its package or type arguments, such as map for
+// java.util.Map<String,Object>.
+func baseType(parameter *broker.EffectorParameter) string {
+	typeName := strings.ToLower(parameter.Type)
+	if lt := strings.Index(typeName, "<"); lt >= 0 {
+		// the type arguments may hold dots of their own
+		typeName = typeName[:lt]
+	}
+	return typeName[strings.LastIndex(typeName, ".")+1:]
+}
+
+func describeType(typeName string) string {
+	switch typeName {
+	case "boolean":
+		return "boolean, true or false"
+	case "map":
+		return "JSON object"
+	case "list", "collection", "set", "iterable":
+		return "JSON list"
+	case "double", "float", "number", "bigdecimal":
+		return "number"
+	}
+	return "whole number"
+}
+
+// InvokeOptions give further sources of parameters for InvokeEffector,
+// and whether to wait for the effector to finish. Parameters given in
+// more than one place take the value from the last of ParamsFile,
+// Params, Param and the --NAME VALUE arguments.
+type InvokeOptions struct {
+	// ParamsFile is a JSON or YAML file holding a map of parameters.
+	ParamsFile string
+	// Params is a JSON map of parameters, or - to read it from stdin.
+	Params string
+	// Param holds NAME=VALUE pairs, where a VALUE of @FILE is replaced
+	// by the contents of FILE.
+	Param []string
+	// Wait polls the task running the effector until it is done.
+	Wait bool
+	// Async returns as soon as the effector has started, printing the
+	// id of its task.
+	Async bool
+}
+
+// gatherParams collects the parameters from every source in the
+// options and the command line arguments. Values read from files or
+// JSON may already be typed, while the rest are strings.
+func gatherParams(options InvokeOptions, args []string) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+	if options.ParamsFile != "" {
+		fromFile, err := readParamsFile(options.ParamsFile)
+		if err != nil {
+			return nil, err
+		}
+		merge(params, fromFile)
+	}
+	if options.Params != "" {
+		fromJSON, err := readParamsJSON(options.Params)
+		if err != nil {
+			return nil, err
+		}
+		merge(params, fromJSON)
+	}
+	for _, pair := range options.Param {
+		eq := strings.Index(pair, "=")
+		if eq <= 0 {
+			return nil, failure.NewUsageError("invalid --param %q, expected NAME=VALUE or NAME=@FILE", pair)
+		}
+		value := pair[eq+1:]
+		if strings.HasPrefix(value, "@") {
+			contents, err := ioutil.ReadFile(value[1:])
+			if err != nil {
+				return nil, err
+			}
+			value = string(contents)
+		}
+		params[pair[:eq]] = value
+	}
+	given, err := parseParams(args)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range given {
+		params[k] = v
+	}
+	return params, nil
+}
+
+func merge(params, from map[string]interface{}) {
+	for k, v := range from {
+		params[k] = v
+	}
+}
+
+// readParamsFile reads a map of parameters from a file, as JSON if its
+// name ends in .json or else as YAML.
+func readParamsFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return decodeParams(path, data)
+	}
+	var document interface{}
+	if err := candiedyaml.Unmarshal(data, &document); err != nil {
+		return nil, failure.NewUsageError("could not read parameters from %s: %s", path, err)
+	}
+	params, isMap := io.JSONCompatible(document).(map[string]interface{})
+	if !isMap {
+		return nil, failure.NewUsageError("%s should hold a map of parameter names to values", path)
+	}
+	return params, nil
+}
+
+func readParamsJSON(params string) (map[string]interface{}, error) {
+	source := "--params"
+	data := []byte(params)
+	if params == "-" {
+		source = "standard input"
+		var err error
+		if data, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return nil, err
+		}
+	}
+	return decodeParams(source, data)
+}
+
+func decodeParams(source string, data []byte) (map[string]interface{}, error) {
+	var params map[string]interface{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, failure.NewUsageError("could not read parameters from %s, expected a JSON object: %s", source, err)
+	}
+	return params, nil
+}
@@ -0,0 +1,86 @@
+package effectors
+
+import (
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"reflect"
+	"testing"
+)
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		typeName string
+		value    string
+		want     interface{}
+	}{
+		{"java.lang.Integer", "3", int64(3)},
+		{"java.lang.Integer", "3\n", int64(3)},
+		{"int", "-7", int64(-7)},
+		{"java.lang.Double", "2.5", 2.5},
+		{"java.lang.Boolean", "true", true},
+		{"java.lang.Boolean", "false \n", false},
+		{"java.util.Map", `{"port": 8080}`, map[string]interface{}{"port": 8080.0}},
+		{"java.util.Map<java.lang.String,java.lang.Object>", `{"a": "b"}`, map[string]interface{}{"a": "b"}},
+		{"java.util.List<java.lang.String>", `["a", "b"]`, []interface{}{"a", "b"}},
+		{"java.lang.String", "text\n", "text\n"},
+		{"", "anything", "anything"},
+	}
+	for _, test := range tests {
+		parameter := &broker.EffectorParameter{Name: "p", Type: test.typeName}
+		got, err := coerce(parameter, test.value)
+		if err != nil {
+			t.Errorf("coerce(%s, %q) failed: %s", test.typeName, test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("coerce(%s, %q) = %#v, want %#v", test.typeName, test.value, got, test.want)
+		}
+	}
+}
+
+func TestCoerceErrors(t *testing.T) {
+	tests := []struct {
+		typeName string
+		value    string
+	}{
+		{"java.lang.Integer", "three"},
+		{"java.lang.Integer", "2.5"},
+		{"java.lang.Double", "x"},
+		{"java.lang.Boolean", "yes please"},
+		{"java.util.Map<String,Object>", "[1]"},
+		{"java.util.List", `{"a": 1}`},
+	}
+	for _, test := range tests {
+		parameter := &broker.EffectorParameter{Name: "p", Type: test.typeName}
+		if _, err := coerce(parameter, test.value); !failure.IsUsageError(err) {
+			t.Errorf("coerce(%s, %q) returned %v, want a usage error", test.typeName, test.value, err)
+		}
+	}
+}
+
+func TestCheckType(t *testing.T) {
+	tests := []struct {
+		typeName string
+		value    interface{}
+		valid    bool
+	}{
+		{"java.lang.Integer", 3.0, true},
+		{"java.lang.Integer", int64(3), true},
+		{"java.lang.Integer", 3.5, false},
+		{"java.lang.Integer", true, false},
+		{"java.lang.Double", 3.5, true},
+		{"java.lang.Boolean", true, true},
+		{"java.lang.Boolean", 1.0, false},
+		{"java.util.Map<String,Object>", map[string]interface{}{}, true},
+		{"java.util.Map", []interface{}{}, false},
+		{"java.util.List<String>", []interface{}{"a"}, true},
+		{"java.lang.String", 3.0, true},
+	}
+	for _, test := range tests {
+		parameter := &broker.EffectorParameter{Name: "p", Type: test.typeName}
+		err := checkType(parameter, test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("checkType(%s, %#v) returned %v, want valid %t", test.typeName, test.value, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package effectors
+
+import (
+	"fmt"
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"github.com/cloudfoundry/cli/cf/terminal"
+	"strconv"
+	"strings"
+)
+
+// attempts is how many times an answer is asked for before giving up,
+// so that a closed stdin does not leave the plugin asking forever.
+const attempts = 3
+
+// pickEffector lists the effectors of the service and asks which one
+// to invoke, leaving out those of entities the broker cannot address.
+func (c *EffectorCommand) pickEffector(service string, effectors *broker.EffectorTree, index *entityIndex) (*entityEffector, error) {
+	list := []*entityEffector{}
+	for _, effector := range listEffectors(effectors) {
+		_, err := index.target(effector.Entity)
+		if failure.IsUsageError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, effector)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("service %s has no effectors", service)
+	}
+	fmt.Println("Effectors of", terminal.ColorizeBold(service, 32))
+	for i, effector := range list {
+		fmt.Printf("%3d. %-30s %-20s %s\n", i+1, effector.Entity, terminal.ColorizeBold(effector.Name, 31), effector.Description)
+	}
+	for i := 0; i < attempts; i++ {
+		answer := strings.TrimSpace(c.ui.Ask("Effector to invoke (1-%d)", len(list)))
+		if choice, err := strconv.Atoi(answer); err == nil && choice >= 1 && choice <= len(list) {
+			return list[choice-1], nil
+		}
+		fmt.Printf("Enter a number from 1 to %d.\n", len(list))
+	}
+	return nil, failure.NewUsageError("no effector chosen")
+}
+
+// askParams asks for each parameter of the effector that has not been
+// given already. Those left blank take their default value.
+func (c *EffectorCommand) askParams(effector *broker.Effector, given map[string]interface{}) (map[string]interface{}, error) {
+	for _, parameter := range effector.Parameters {
+		if _, found := given[parameter.Name]; found {
+			continue
+		}
+		if parameter.Description != "" {
+			fmt.Printf("%s: %s\n", terminal.ColorizeBold(parameter.Name, 36), parameter.Description)
+		}
+		prompt := parameter.Name
+		if parameter.DefaultValue != nil {
+			prompt = fmt.Sprintf("%s [%v]", prompt, parameter.DefaultValue)
+		}
+		answer, err := c.askParam(prompt, parameter.DefaultValue == nil)
+		if err != nil {
+			return nil, err
+		}
+		if answer != "" {
+			given[parameter.Name] = answer
+		}
+	}
+	return given, nil
+}
+
+func (c *EffectorCommand) askParam(prompt string, required bool) (string, error) {
+	for i := 0; i < attempts; i++ {
+		answer := c.ui.Ask("%s", prompt)
+		if answer != "" || !required {
+			return answer, nil
+		}
+		fmt.Println("This parameter is required.")
+	}
+	return "", failure.NewUsageError("no value given for required parameter %s", prompt)
+}
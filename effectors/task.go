@@ -0,0 +1,77 @@
+package effectors
+
+import (
+	"fmt"
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/output"
+	"os"
+	"time"
+)
+
+const taskPollInterval = time.Second
+
+var spinner = []rune{'|', '/', '-', '\\'}
+
+// ShowTask prints the state of a task, such as one started by invoking
+// an effector with --async.
+func (c *EffectorCommand) ShowTask(cred *broker.BrokerCredentials, service, id, format string) error {
+	guid, err := broker.ServiceGuid(c.cliConnection, service)
+	if err != nil {
+		return err
+	}
+	task, err := broker.NewClient(c.cliConnection, cred).Task(guid, id)
+	if err != nil {
+		return err
+	}
+	if output.IsMachineReadable(format) {
+		return output.Write(os.Stdout, format, task)
+	}
+	printTask(task)
+	return nil
+}
+
+// waitForTask polls the task, showing a spinner and its status, until
+// it is done. A task ending in error is returned as an error.
+func waitForTask(client *broker.Client, guid string, task *broker.Task) error {
+	var err error
+	for i := 0; !task.IsDone(); i++ {
+		fmt.Printf("\r%c %-40s", spinner[i%len(spinner)], task.CurrentStatus)
+		time.Sleep(taskPollInterval)
+		if task, err = client.Task(guid, task.Id); err != nil {
+			fmt.Println()
+			return err
+		}
+	}
+	fmt.Printf("\r%-42s\r", "")
+	printTask(task)
+	return taskError(task)
+}
+
+func taskError(task *broker.Task) error {
+	switch {
+	case task.IsCancelled:
+		return fmt.Errorf("task %s was cancelled", task.Id)
+	case task.IsError:
+		return fmt.Errorf("task %s failed: %v", task.Id, task.Result)
+	}
+	return nil
+}
+
+func printTask(task *broker.Task) {
+	fmt.Printf("%-10s %s\n", "Task:", task.Id)
+	fmt.Printf("%-10s %s\n", "Name:", task.DisplayName)
+	fmt.Printf("%-10s %s\n", "Status:", task.CurrentStatus)
+	if task.StartTimeUtc > 0 {
+		fmt.Printf("%-10s %s\n", "Started:", formatTime(task.StartTimeUtc))
+	}
+	if task.EndTimeUtc > 0 {
+		fmt.Printf("%-10s %s\n", "Ended:", formatTime(task.EndTimeUtc))
+	}
+	if task.IsDone() && task.Result != nil {
+		fmt.Printf("%-10s %v\n", "Result:", task.Result)
+	}
+}
+
+func formatTime(millis int64) string {
+	return time.Unix(0, millis*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
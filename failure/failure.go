@@ -0,0 +1,99 @@
+package failure
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Exit codes returned by the plugin, so that scripts can tell the
+// categories of failure apart.
+const (
+	ExitOk          = 0
+	ExitError       = 1
+	ExitUsage       = 2
+	ExitAuth        = 3
+	ExitUnreachable = 4
+	ExitTimeout     = 5
+)
+
+// UsageError is returned when a command is invoked with missing or
+// malformed arguments.
+type UsageError struct {
+	Message string
+}
+
+func (e *UsageError) Error() string {
+	return e.Message
+}
+
+func NewUsageError(format string, args ...interface{}) error {
+	return &UsageError{fmt.Sprintf(format, args...)}
+}
+
+// TimeoutError is returned when an operation gives up waiting.
+type TimeoutError struct {
+	Message string
+}
+
+func (e *TimeoutError) Error() string {
+	return e.Message
+}
+
+func NewTimeoutError(format string, args ...interface{}) error {
+	return &TimeoutError{fmt.Sprintf(format, args...)}
+}
+
+// IsUsageError reports whether err was caused by a bad invocation.
+func IsUsageError(err error) bool {
+	var usageErr *UsageError
+	return errors.As(err, &usageErr)
+}
+
+// ExitCode maps an error returned by a command to the exit code of
+// its category.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOk
+	}
+	var usageErr *UsageError
+	if errors.As(err, &usageErr) {
+		return ExitUsage
+	}
+	var noSuchBrokerErr *broker.NoSuchBrokerError
+	if errors.As(err, &noSuchBrokerErr) {
+		return ExitUsage
+	}
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return ExitTimeout
+	}
+	var brokerErr *broker.BrokerError
+	if errors.As(err, &brokerErr) {
+		switch brokerErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ExitAuth
+		case http.StatusBadGateway, http.StatusServiceUnavailable:
+			return ExitUnreachable
+		case http.StatusGatewayTimeout:
+			return ExitTimeout
+		}
+		return ExitError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ExitTimeout
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return ExitUnreachable
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return ExitUnreachable
+	}
+	return ExitError
+}
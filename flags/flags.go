@@ -0,0 +1,173 @@
+package flags
+
+import (
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FlagSet parses the arguments of a brooklyn subcommand. Unlike the
+// standard flag package, flags may appear anywhere among the
+// positional arguments. A flag named "f" is written -f and one named
+// "broker" is written --broker, although either form is accepted.
+type FlagSet struct {
+	name        string
+	flags       map[string]*flagValue
+	passThrough bool
+	args        []string
+}
+
+type flagValue struct {
+	isBool bool
+	isSet  bool
+	set    func(string) error
+}
+
+func NewFlagSet(name string) *FlagSet {
+	flagSet := new(FlagSet)
+	flagSet.name = name
+	flagSet.flags = make(map[string]*flagValue)
+	return flagSet
+}
+
+// PassThrough keeps unknown flags, in order, among the positional
+// arguments rather than rejecting them, for commands that hand their
+// arguments on to another command.
+func (f *FlagSet) PassThrough() {
+	f.passThrough = true
+}
+
+func (f *FlagSet) StringVar(p *string, name string) {
+	f.flags[name] = &flagValue{set: func(value string) error {
+		*p = value
+		return nil
+	}}
+}
+
+// StringsVar defines a flag that may be given more than once, each
+// value being appended to the slice.
+func (f *FlagSet) StringsVar(p *[]string, name string) {
+	f.flags[name] = &flagValue{set: func(value string) error {
+		*p = append(*p, value)
+		return nil
+	}}
+}
+
+func (f *FlagSet) BoolVar(p *bool, name string) {
+	f.flags[name] = &flagValue{isBool: true, set: func(value string) error {
+		b, err := strconv.ParseBool(value)
+		*p = b
+		return err
+	}}
+}
+
+func (f *FlagSet) IntVar(p *int, name string) {
+	f.flags[name] = &flagValue{set: func(value string) error {
+		i, err := strconv.Atoi(value)
+		*p = i
+		return err
+	}}
+}
+
+func (f *FlagSet) DurationVar(p *time.Duration, name string) {
+	f.flags[name] = &flagValue{set: func(value string) error {
+		d, err := time.ParseDuration(value)
+		*p = d
+		return err
+	}}
+}
+
+// Parse parses args, which should not include the command name.
+// Errors are returned as usage errors.
+func (f *FlagSet) Parse(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			f.args = append(f.args, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' || isNumber(arg) {
+			f.args = append(f.args, arg)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+		flag, found := f.flags[name]
+		if !found {
+			if f.passThrough {
+				f.args = append(f.args, arg)
+				continue
+			}
+			return failure.NewUsageError("unknown flag %s for %s", arg, f.name)
+		}
+		if !hasValue {
+			if flag.isBool {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				return failure.NewUsageError("flag %s needs a value", arg)
+			}
+		}
+		if err := flag.set(value); err != nil {
+			return failure.NewUsageError("invalid value %q for flag %s", value, arg)
+		}
+		flag.isSet = true
+	}
+	return nil
+}
+
+// Args returns the positional arguments, along with any unknown flags
+// if the set passes them through.
+func (f *FlagSet) Args() []string {
+	return f.args
+}
+
+// TakeArgs removes the first n positional arguments and returns them.
+func (f *FlagSet) TakeArgs(n int) []string {
+	taken := f.args[:n:n]
+	f.args = f.args[n:]
+	return taken
+}
+
+// IsSet reports whether the named flag was given on the command line.
+func (f *FlagSet) IsSet(name string) bool {
+	flag, found := f.flags[name]
+	return found && flag.isSet
+}
+
+// RequireArgs checks that exactly the named positional arguments were
+// given.
+func (f *FlagSet) RequireArgs(names ...string) error {
+	if len(f.args) != len(names) {
+		return f.argsError(names)
+	}
+	return nil
+}
+
+// RequireArgsAtLeast checks that at least the named positional
+// arguments were given.
+func (f *FlagSet) RequireArgsAtLeast(names ...string) error {
+	if len(f.args) < len(names) {
+		return f.argsError(names)
+	}
+	return nil
+}
+
+func (f *FlagSet) argsError(names []string) error {
+	if len(names) == 0 {
+		return failure.NewUsageError("%s takes no arguments, got %d", f.name, len(f.args))
+	}
+	return failure.NewUsageError("%s expects arguments %s, got %d",
+		f.name, strings.Join(names, " "), len(f.args))
+}
+
+func isNumber(arg string) bool {
+	_, err := strconv.ParseFloat(arg, 64)
+	return err == nil
+}
@@ -0,0 +1,130 @@
+package flags
+
+import (
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type parsed struct {
+	broker   string
+	force    bool
+	parallel int
+	timeout  time.Duration
+	param    []string
+}
+
+func newTestFlagSet(p *parsed, passThrough bool) *FlagSet {
+	flagSet := NewFlagSet("brooklyn test")
+	flagSet.StringVar(&p.broker, "broker")
+	flagSet.BoolVar(&p.force, "force")
+	flagSet.IntVar(&p.parallel, "parallel")
+	flagSet.DurationVar(&p.timeout, "timeout")
+	flagSet.StringsVar(&p.param, "param")
+	if passThrough {
+		flagSet.PassThrough()
+	}
+	return flagSet
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		passThrough bool
+		want        parsed
+		wantArgs    []string
+	}{
+		{
+			name:     "flags anywhere among the arguments",
+			args:     []string{"svc", "--broker", "b", "other", "--force"},
+			want:     parsed{broker: "b", force: true},
+			wantArgs: []string{"svc", "other"},
+		},
+		{
+			name:     "values after an equals sign",
+			args:     []string{"--broker=b", "--force=false", "--parallel=3", "--timeout=1m"},
+			want:     parsed{broker: "b", parallel: 3, timeout: time.Minute},
+			wantArgs: nil,
+		},
+		{
+			name:     "single dash",
+			args:     []string{"-broker", "b"},
+			want:     parsed{broker: "b"},
+			wantArgs: nil,
+		},
+		{
+			name:     "repeated flags",
+			args:     []string{"--param", "a=1", "--param=b=2"},
+			want:     parsed{param: []string{"a=1", "b=2"}},
+			wantArgs: nil,
+		},
+		{
+			name:     "negative numbers are arguments",
+			args:     []string{"-3", "--parallel", "2"},
+			want:     parsed{parallel: 2},
+			wantArgs: []string{"-3"},
+		},
+		{
+			name:     "double dash ends the flags",
+			args:     []string{"svc", "--", "--broker", "b"},
+			wantArgs: []string{"svc", "--broker", "b"},
+		},
+		{
+			name:        "unknown flags passed through in order",
+			args:        []string{"svc", "--size", "3", "--broker", "b", "-x"},
+			passThrough: true,
+			want:        parsed{broker: "b"},
+			wantArgs:    []string{"svc", "--size", "3", "-x"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got parsed
+			flagSet := newTestFlagSet(&got, test.passThrough)
+			if err := flagSet.Parse(test.args); err != nil {
+				t.Fatalf("Parse(%q) failed: %s", test.args, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse(%q) set %+v, want %+v", test.args, got, test.want)
+			}
+			if !reflect.DeepEqual(flagSet.Args(), test.wantArgs) {
+				t.Errorf("Parse(%q) left arguments %q, want %q", test.args, flagSet.Args(), test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"unknown flag", []string{"--size", "3"}},
+		{"missing value", []string{"--broker"}},
+		{"invalid int", []string{"--parallel", "many"}},
+		{"invalid duration", []string{"--timeout", "5"}},
+		{"invalid bool", []string{"--force=maybe"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got parsed
+			err := newTestFlagSet(&got, false).Parse(test.args)
+			if !failure.IsUsageError(err) {
+				t.Errorf("Parse(%q) returned %v, want a usage error", test.args, err)
+			}
+		})
+	}
+}
+
+func TestTakeArgs(t *testing.T) {
+	flagSet := NewFlagSet("brooklyn test")
+	if err := flagSet.Parse([]string{"b", "u", "p", "svc"}); err != nil {
+		t.Fatal(err)
+	}
+	taken := flagSet.TakeArgs(3)
+	if !reflect.DeepEqual(taken, []string{"b", "u", "p"}) || !reflect.DeepEqual(flagSet.Args(), []string{"svc"}) {
+		t.Errorf("TakeArgs(3) took %q and left %q", taken, flagSet.Args())
+	}
+}
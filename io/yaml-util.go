@@ -1,8 +1,7 @@
 package io
 
 import (
-	//"fmt"
-	"github.com/cloudfoundry-community/brooklyn-plugin/assert"
+	"fmt"
 	"github.com/cloudfoundry-incubator/candiedyaml"
 	"github.com/cloudfoundry/cli/cf/errors"
 	. "github.com/cloudfoundry/cli/cf/i18n"
@@ -12,22 +11,23 @@ import (
 	"path/filepath"
 )
 
-func ReadYAMLFile(path string) generic.Map {
+func ReadYAMLFile(path string) (generic.Map, error) {
 	file, err := os.Open(filepath.Clean(path))
-	assert.ErrorIsNil(err)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
-	yamlMap, err := parse(file)
-	assert.ErrorIsNil(err)
-	return yamlMap
+	return parse(file)
 }
 
 func parse(file io.Reader) (yamlMap generic.Map, err error) {
 	decoder := candiedyaml.NewDecoder(file)
 	yamlMap = generic.NewMap()
 	err = decoder.Decode(yamlMap)
-
-	assert.ErrorIsNil(err)
+	if err != nil {
+		return
+	}
 
 	if !generic.IsMappable(yamlMap) {
 		err = errors.New(T("Invalid. Expected a map"))
@@ -37,15 +37,70 @@ func parse(file io.Reader) (yamlMap generic.Map, err error) {
 	return
 }
 
-func WriteYAMLFile(yamlMap generic.Map, path string) {
+func WriteYAMLFile(yamlMap generic.Map, path string) error {
 
 	fileToWrite, err := os.Create(path)
-	assert.ErrorIsNil(err)
+	if err != nil {
+		return err
+	}
+	defer fileToWrite.Close()
+
+	return encode(fileToWrite, yamlMap)
+}
+
+// WriteYAMLFileWithPerm writes the file with the given permissions,
+// replacing any existing file. It writes a temporary file alongside and
+// renames it over the old one, so a failed write leaves the old file
+// as it was.
+func WriteYAMLFileWithPerm(yamlMap generic.Map, path string, perm os.FileMode) error {
+	fileToWrite, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := fileToWrite.Name()
+	err = fileToWrite.Chmod(perm)
+	if err == nil {
+		err = encode(fileToWrite, yamlMap)
+	}
+	if closeErr := fileToWrite.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
 
-	encoder := candiedyaml.NewEncoder(fileToWrite)
-	err = encoder.Encode(yamlMap)
+// JSONCompatible converts the maps decoded from YAML, which may have
+// keys of any type, to maps with string keys that can be encoded as
+// JSON.
+func JSONCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for k, value := range v {
+			m[fmt.Sprint(k)] = JSONCompatible(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = JSONCompatible(value)
+		}
+		return l
+	}
+	return v
+}
 
-	assert.ErrorIsNil(err)
+// WriteYAML writes the map as YAML to any writer, such as stdout.
+func WriteYAML(writer io.Writer, yamlMap generic.Map) error {
+	return encode(writer, yamlMap)
+}
 
-	return
+func encode(file io.Writer, yamlMap generic.Map) error {
+	encoder := candiedyaml.NewEncoder(file)
+	return encoder.Encode(yamlMap)
 }
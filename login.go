@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/credentials"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"github.com/cloudfoundry-community/brooklyn-plugin/flags"
+	"github.com/cloudfoundry/cli/cf/terminal"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// isCredentialsCommand reports whether the command only manages the
+// stored credentials, rather than talking to a broker.
+func isCredentialsCommand(command string) bool {
+	switch command {
+	case "login", "logout", "targets", "target":
+		return true
+	}
+	return false
+}
+
+// loginFlags let login run without a terminal, with any of the broker,
+// username and password not given by flags read from the environment.
+type loginFlags struct {
+	force         bool
+	broker        string
+	username      string
+	passwordStdin bool
+}
+
+func (l *loginFlags) register(flagSet *flags.FlagSet) {
+	flagSet.BoolVar(&l.force, "force")
+	flagSet.StringVar(&l.broker, "broker")
+	flagSet.StringVar(&l.username, "username")
+	flagSet.BoolVar(&l.passwordStdin, "password-stdin")
+}
+
+func (c *BrooklynPlugin) runCredentialsCommand(command string, flagSet *flags.FlagSet, login loginFlags) error {
+	args := flagSet.Args()
+	switch command {
+	case "login":
+		if err := flagSet.RequireArgs(); err != nil {
+			return err
+		}
+		return c.login(login)
+	case "logout":
+		if len(args) > 1 {
+			return flagSet.RequireArgs("[BROKER]")
+		}
+		broker := c.store.Target()
+		if len(args) == 1 {
+			broker = args[0]
+		}
+		return c.logout(broker)
+	case "targets":
+		if err := flagSet.RequireArgs(); err != nil {
+			return err
+		}
+		c.targets()
+	case "target":
+		if len(args) > 1 {
+			return flagSet.RequireArgs("[BROKER]")
+		}
+		if len(args) == 0 {
+			return c.showTarget()
+		}
+		return c.target(args[0])
+	}
+	return nil
+}
+
+// nonInteractive reports whether login was given a password on stdin
+// or in the environment, in which case it runs without a terminal and
+// must never prompt.
+func (l *loginFlags) nonInteractive() bool {
+	return l.passwordStdin || os.Getenv(credentials.PasswordEnv) != ""
+}
+
+// checkNonInteractive fails if anything login would otherwise prompt
+// for is missing. It is checked before the store is loaded, as loading
+// it may need the passphrase.
+func (l *loginFlags) checkNonInteractive() error {
+	source := credentials.PasswordEnv
+	if l.passwordStdin {
+		source = "--password-stdin"
+	}
+	switch {
+	case firstNonEmpty(l.broker, os.Getenv(credentials.BrokerEnv)) == "":
+		return failure.NewUsageError("%s needs a broker from --broker or %s", source, credentials.BrokerEnv)
+	case firstNonEmpty(l.username, os.Getenv(credentials.UsernameEnv)) == "":
+		return failure.NewUsageError("%s needs a username from --username or %s", source, credentials.UsernameEnv)
+	case os.Getenv(credentials.PassphraseEnv) == "":
+		return failure.NewUsageError("%s needs the passphrase protecting stored passwords in %s", source, credentials.PassphraseEnv)
+	}
+	return nil
+}
+
+func (c *BrooklynPlugin) login(login loginFlags) error {
+	brokerName := firstNonEmpty(login.broker, os.Getenv(credentials.BrokerEnv))
+	user := firstNonEmpty(login.username, os.Getenv(credentials.UsernameEnv))
+	if login.nonInteractive() {
+		pass := os.Getenv(credentials.PasswordEnv)
+		if login.passwordStdin {
+			var err error
+			if pass, err = readPassword(os.Stdin); err != nil {
+				return err
+			}
+		}
+		if err := c.storeCredentials(brokerName, user, pass); err != nil {
+			return err
+		}
+		c.store.SetTarget(brokerName)
+		return c.store.Save()
+	}
+
+	if brokerName == "" {
+		brokerName = c.ui.Ask("Broker")
+	}
+	if user != "" || login.force || !c.store.Has(brokerName) {
+		if user == "" {
+			user = c.ui.Ask("Username")
+		}
+		pass := c.ui.AskForPassword("Password")
+		if err := c.storeCredentials(brokerName, user, pass); err != nil {
+			return err
+		}
+	}
+	c.store.SetTarget(brokerName)
+	return c.store.Save()
+}
+
+func (c *BrooklynPlugin) storeCredentials(brokerName, user, pass string) error {
+	if err := c.verifyCredentials(broker.NewBrokerCredentials(brokerName, user, pass)); err != nil {
+		return err
+	}
+	return c.store.Set(brokerName, user, pass)
+}
+
+// readPassword reads a password piped to the plugin, ignoring the
+// trailing newline.
+func readPassword(reader io.Reader) (string, error) {
+	input, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	pass := strings.TrimRight(string(input), "\r\n")
+	if pass == "" {
+		return "", failure.NewUsageError("no password given on standard input")
+	}
+	return pass, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// verifyCredentials checks that the broker is registered with Cloud
+// Foundry and accepts the credentials, saying which it was if not.
+func (c *BrooklynPlugin) verifyCredentials(cred *broker.BrokerCredentials) error {
+	brokerUrl, err := broker.ServiceBrokerUrl(c.cliConnection, cred.Broker)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Checking credentials with broker", terminal.EntityNameColor(cred.Broker), "at", brokerUrl)
+	_, err = broker.NewClient(c.cliConnection, cred).Catalog()
+	if err == nil {
+		return nil
+	}
+	var brokerErr *broker.BrokerError
+	if errors.As(err, &brokerErr) {
+		switch brokerErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("broker %s rejected the username or password: %w", cred.Broker, err)
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("could not reach broker %s at %s: %w", cred.Broker, brokerUrl, err)
+	}
+	return fmt.Errorf("broker %s failed to check the credentials: %w", cred.Broker, err)
+}
+
+func (c *BrooklynPlugin) logout(broker string) error {
+	if broker == "" {
+		return failure.NewUsageError("target not set, name the broker to log out of")
+	}
+	if !c.store.Has(broker) {
+		return failure.NewUsageError("no credentials stored for broker %s", broker)
+	}
+	c.store.Remove(broker)
+	fmt.Println("Removed credentials for broker", terminal.EntityNameColor(broker))
+	return c.store.Save()
+}
+
+func (c *BrooklynPlugin) targets() {
+	brokers := c.store.Brokers()
+	if len(brokers) == 0 {
+		fmt.Printf("No brokers stored for %s, use cf brooklyn login\n", c.store.Scope())
+		return
+	}
+	fmt.Println("Brokers for", terminal.EntityNameColor(c.store.Scope().String()))
+	target := c.store.Target()
+	for _, broker := range brokers {
+		if broker == target {
+			fmt.Println("*", terminal.EntityNameColor(broker))
+		} else {
+			fmt.Println(" ", broker)
+		}
+	}
+}
+
+func (c *BrooklynPlugin) showTarget() error {
+	target := c.store.Target()
+	if target == "" {
+		return failure.NewUsageError("target not set, use cf brooklyn login")
+	}
+	fmt.Println("Broker:", terminal.EntityNameColor(target))
+	return nil
+}
+
+func (c *BrooklynPlugin) target(broker string) error {
+	if !c.store.Has(broker) {
+		return failure.NewUsageError("no credentials stored for broker %s, use cf brooklyn login", broker)
+	}
+	c.store.SetTarget(broker)
+	fmt.Println("Broker:", terminal.EntityNameColor(broker))
+	return c.store.Save()
+}
@@ -0,0 +1,49 @@
+package output
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"github.com/cloudfoundry-incubator/candiedyaml"
+	"io"
+)
+
+// Formats accepted by --output. Text is the default, human readable,
+// rendering of each command.
+const (
+	Text = "text"
+	JSON = "json"
+	YAML = "yaml"
+)
+
+// Validate checks the format given to --output.
+func Validate(format string) error {
+	switch format {
+	case "", Text, JSON, YAML:
+		return nil
+	}
+	return failure.NewUsageError("unknown output format %q, expected json or yaml", format)
+}
+
+// IsMachineReadable reports whether the format is meant for scripts,
+// in which case nothing else should be written to standard output.
+func IsMachineReadable(format string) bool {
+	return format == JSON || format == YAML
+}
+
+// Write writes v in the given format. Both formats are produced from
+// the JSON encoding of v.
+func Write(writer io.Writer, format string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if format == JSON {
+		_, err = writer.Write(append(data, '\n'))
+		return err
+	}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	return candiedyaml.NewEncoder(writer).Encode(document)
+}
@@ -0,0 +1,121 @@
+package push
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/io"
+	"github.com/cloudfoundry/cli/generic"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	firstVersion = "1.0"
+	// descriptionPrefix marks the catalog items created by push, which
+	// are the only ones push publishes new versions of.
+	descriptionPrefix = "A user defined blueprint"
+)
+
+// descriptionPattern picks the version and blueprint hash out of the
+// description of a catalog item created by push.
+var descriptionPattern = regexp.MustCompile(`\(version (\S+), sha256 ([0-9a-f]+)\)`)
+
+// planCatalogItem adds the blueprint to the plan if the catalog has no
+// item with that name, or if the item was created by an earlier push
+// from a different blueprint, in which case the new blueprint is given
+// the next version. Items from older versions of the plugin record no
+// hash, so whether they changed is unknown; they are published again to
+// record it, but not counted as changed, so existing services are
+// reused.
+func (c *PushCommand) planCatalogItem(name string, blueprints []interface{}, location map[interface{}]interface{}) error {
+	hash, err := blueprintHash(blueprints, location)
+	if err != nil {
+		return err
+	}
+	existing, err := c.catalogService(name)
+	if err != nil {
+		return err
+	}
+	version, changed := firstVersion, false
+	if existing != nil {
+		if !strings.HasPrefix(existing.Description, descriptionPrefix) {
+			// added some other way, so leave it alone
+			return nil
+		}
+		previousVersion, previousHash := parseDescription(existing.Description)
+		if previousHash == hash {
+			return nil
+		}
+		version, changed = nextVersion(previousVersion), previousHash != ""
+	}
+	description := fmt.Sprintf("%s (version %s, sha256 %s)", descriptionPrefix, version, hash)
+	yamlMap := c.createCatalogYamlMap(name, version, description, blueprints)
+	if location != nil {
+		yamlMap.Set("location", generic.NewMap(location))
+	}
+	c.plan.addCatalogItem(name, version, changed, yamlMap)
+	return nil
+}
+
+// catalogService returns the service the broker offers for a catalog
+// item, or nil if there is none.
+func (c *PushCommand) catalogService(name string) (*broker.CatalogService, error) {
+	if c.catalog == nil {
+		catalog, err := broker.NewClient(c.cliConnection, c.credentials).Catalog()
+		if err != nil {
+			return nil, err
+		}
+		c.catalog = catalog
+	}
+	for _, service := range c.catalog.Services {
+		if service.Name == name {
+			return service, nil
+		}
+	}
+	return nil, nil
+}
+
+// blueprintHash returns a short hash of the blueprint and its location,
+// which changes whenever either of them does.
+func blueprintHash(blueprints []interface{}, location map[interface{}]interface{}) (string, error) {
+	content := map[string]interface{}{"services": io.JSONCompatible(blueprints)}
+	if location != nil {
+		content["location"] = io.JSONCompatible(location)
+	}
+	// json orders map keys, so equal blueprints encode the same way
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// parseDescription returns the version and hash recorded in the
+// description, which are empty for items from older versions of the
+// plugin.
+func parseDescription(description string) (version, hash string) {
+	match := descriptionPattern.FindStringSubmatch(description)
+	if match == nil {
+		return "", ""
+	}
+	return match[1], match[2]
+}
+
+// nextVersion increments the last part of a version such as 1.3. Items
+// from older versions of the plugin were always given version 1.0.
+func nextVersion(version string) string {
+	if version == "" {
+		version = firstVersion
+	}
+	dot := strings.LastIndex(version, ".")
+	minor, err := strconv.Atoi(version[dot+1:])
+	if err != nil {
+		return version + ".1"
+	}
+	return version[:dot+1] + strconv.Itoa(minor+1)
+}
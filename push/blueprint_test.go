@@ -0,0 +1,38 @@
+package push
+
+import (
+	"testing"
+)
+
+func TestNextVersion(t *testing.T) {
+	tests := []struct {
+		version, want string
+	}{
+		{"", "1.1"},
+		{"1.0", "1.1"},
+		{"1.9", "1.10"},
+		{"2.3.4", "2.3.5"},
+		{"7", "8"},
+		{"1.0-beta", "1.0-beta.1"},
+	}
+	for _, test := range tests {
+		if got := nextVersion(test.version); got != test.want {
+			t.Errorf("nextVersion(%q) = %q, want %q", test.version, got, test.want)
+		}
+	}
+}
+
+func TestParseDescription(t *testing.T) {
+	tests := []struct {
+		description, version, hash string
+	}{
+		{descriptionPrefix + " (version 1.2, sha256 0123abcd)", "1.2", "0123abcd"},
+		{descriptionPrefix, "", ""},
+	}
+	for _, test := range tests {
+		version, hash := parseDescription(test.description)
+		if version != test.version || hash != test.hash {
+			t.Errorf("parseDescription(%q) = %q, %q, want %q, %q", test.description, version, hash, test.version, test.hash)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package push
+
+import (
+	"sync"
+)
+
+const defaultParallel = 4
+
+// forEach calls fn for 0 to n-1, running at most workers calls at a
+// time, and returns the error of the lowest index that failed.
+//
+// fn must not call the CLI through the plugin connection. The CLI runs a
+// plugin's commands one at a time over RPC, capturing their output in a
+// single buffer, so calls from several goroutines would at best wait
+// for each other and at worst mix up their output. Requests made
+// straight to the broker, or cf commands run as processes of their own,
+// do run concurrently.
+func forEach(n, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = defaultParallel
+	}
+	errs := make([]error, n)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
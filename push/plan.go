@@ -0,0 +1,282 @@
+package push
+
+import (
+	"fmt"
+	"github.com/cloudfoundry-community/brooklyn-plugin/io"
+	"github.com/cloudfoundry/cli/cf/terminal"
+	"github.com/cloudfoundry/cli/generic"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// pushPlan is what a push will do before handing the rewritten
+// manifest to cf push, worked out from the manifest before anything is
+// changed.
+type pushPlan struct {
+	catalogItems []*catalogItem
+	services     []*serviceInstance
+}
+
+// catalogItem is a blueprint to add to the Brooklyn catalog.
+type catalogItem struct {
+	name    string
+	version string
+	// changed is set when the item replaces an earlier version made from
+	// a different blueprint.
+	changed bool
+	yamlMap generic.Map
+}
+
+func (i *catalogItem) String() string {
+	if i.changed {
+		return fmt.Sprintf("%s version %s, blueprint changed", i.name, i.version)
+	}
+	return fmt.Sprintf("%s version %s", i.name, i.version)
+}
+
+// serviceInstance is a service to create from a plan of a service
+// offered by the broker.
+type serviceInstance struct {
+	service string
+	plan    string
+	name    string
+	// existing is the instance of the same name already in the space,
+	// if there is one.
+	existing *serviceInstance
+	// started is set once the instance has been created, so that push
+	// waits for it to be running.
+	started bool
+}
+
+// drifted reports whether an instance of the same name exists but was
+// created from a different service or plan.
+func (s *serviceInstance) drifted() bool {
+	return s.existing != nil && (s.existing.service != s.service || s.existing.plan != s.plan)
+}
+
+func (s *serviceInstance) String() string {
+	return fmt.Sprintf("service %s, plan %s", s.service, s.plan)
+}
+
+func (p *pushPlan) addCatalogItem(name, version string, changed bool, yamlMap generic.Map) {
+	for _, item := range p.catalogItems {
+		if item.name == name {
+			return
+		}
+	}
+	p.catalogItems = append(p.catalogItems, &catalogItem{name, version, changed, yamlMap})
+}
+
+// changedBlueprint reports whether an existing instance of the service
+// instance was created from an earlier version of a blueprint in the
+// plan. The broker cannot move an instance to a new version, so it
+// keeps running the earlier one.
+func (p *pushPlan) changedBlueprint(instance *serviceInstance) bool {
+	if instance.existing == nil {
+		return false
+	}
+	for _, item := range p.catalogItems {
+		if item.name == instance.service && item.changed {
+			return true
+		}
+	}
+	return false
+}
+
+// planService adds a service instance to the plan, noting any existing
+// instance of the same name so that it is reused rather than created
+// again.
+func (c *PushCommand) planService(service, plan, name string) error {
+	for _, instance := range c.plan.services {
+		if instance.name == name {
+			return nil
+		}
+	}
+	existing, err := c.existingService(name)
+	if err != nil {
+		return err
+	}
+	c.plan.services = append(c.plan.services, &serviceInstance{service: service, plan: plan, name: name, existing: existing})
+	return nil
+}
+
+// existingService looks up the service instance with the given name in
+// the targeted space, returning nil if there is none.
+func (c *PushCommand) existingService(name string) (*serviceInstance, error) {
+	lines, err := c.cliConnection.CliCommandWithoutTerminalOutput("service", name)
+	for _, line := range lines {
+		if strings.Contains(line, "not found") {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	existing := &serviceInstance{name: name}
+	for _, line := range lines {
+		// older versions of cf capitalise the field names
+		field := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(field) != 2 {
+			continue
+		}
+		switch strings.ToLower(field[0]) {
+		case "service":
+			existing.service = strings.TrimSpace(field[1])
+		case "plan":
+			existing.plan = strings.TrimSpace(field[1])
+		}
+	}
+	return existing, nil
+}
+
+// drift describes the existing service instances that differ from the
+// manifest, or returns nil if there are none.
+func (p *pushPlan) drift() error {
+	var drifted []string
+	for _, instance := range p.services {
+		if instance.drifted() {
+			drifted = append(drifted, fmt.Sprintf("%s exists with %s, but the manifest asks for %s",
+				instance.name, instance.existing, instance))
+		}
+	}
+	if len(drifted) == 0 {
+		return nil
+	}
+	return fmt.Errorf("service instances differ from the manifest, delete or update them first:\n  %s",
+		strings.Join(drifted, "\n  "))
+}
+
+// stale describes the existing service instances created from an
+// earlier version of a blueprint in the plan, or returns nil if there
+// are none.
+func (p *pushPlan) stale() error {
+	var stale []string
+	for _, instance := range p.services {
+		if p.changedBlueprint(instance) {
+			stale = append(stale, instance.name)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return fmt.Errorf("service instances were created from an earlier version of their blueprint, "+
+		"delete them first or push with --keep-services to reuse them as they are:\n  %s",
+		strings.Join(stale, "\n  "))
+}
+
+// startedServiceNames returns the names of the instances created by the
+// push, leaving out those reused as they were.
+func (p *pushPlan) startedServiceNames() []string {
+	names := []string{}
+	for _, instance := range p.services {
+		if instance.started {
+			names = append(names, instance.name)
+		}
+	}
+	return names
+}
+
+func (c *PushCommand) executePlan() error {
+	if err := c.plan.drift(); err != nil {
+		return err
+	}
+	if !c.options.KeepServices {
+		if err := c.plan.stale(); err != nil {
+			return err
+		}
+	}
+	for _, item := range c.plan.catalogItems {
+		if err := c.createNewCatalogItem(item.name, item.yamlMap); err != nil {
+			return err
+		}
+	}
+	var create []*serviceInstance
+	for _, instance := range c.plan.services {
+		if c.plan.changedBlueprint(instance) {
+			fmt.Printf("Using existing service %s, which still runs the previous version of its blueprint\n",
+				terminal.ColorizeBold(instance.name, 36))
+			continue
+		}
+		if instance.existing != nil {
+			fmt.Println("Using existing service", terminal.ColorizeBold(instance.name, 36))
+			continue
+		}
+		create = append(create, instance)
+	}
+	for _, instance := range create {
+		fmt.Println("Creating service", terminal.ColorizeBold(instance.name, 36))
+	}
+	return forEach(len(create), c.options.Parallel, func(i int) error {
+		instance := create[i]
+		if err := createService(instance); err != nil {
+			return err
+		}
+		instance.started = true
+		return nil
+	})
+}
+
+// createService runs cf create-service for the instance as a process of
+// its own, as the CLI connection of the plugin runs one command at a
+// time, so that several services can be provisioned at once.
+func createService(instance *serviceInstance) error {
+	out, err := exec.Command("cf", "create-service", instance.service, instance.plan, instance.name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not create service %s: %s\n%s", instance.name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// printPlan reports what the push would do, followed by the manifest
+// that would be given to cf push.
+func (c *PushCommand) printPlan(args []string) error {
+	fmt.Println(terminal.ColorizeBold("Catalog items to add:", 32))
+	if len(c.plan.catalogItems) == 0 {
+		fmt.Println("  none")
+	}
+	for _, item := range c.plan.catalogItems {
+		fmt.Println(" ", item)
+	}
+	var create, stale, reuse, drifted []*serviceInstance
+	for _, instance := range c.plan.services {
+		switch {
+		case instance.drifted():
+			drifted = append(drifted, instance)
+		case c.plan.changedBlueprint(instance):
+			stale = append(stale, instance)
+		case instance.existing != nil:
+			reuse = append(reuse, instance)
+		default:
+			create = append(create, instance)
+		}
+	}
+	printServices("Services to create:", create)
+	if len(stale) > 0 {
+		heading := "Existing services from an earlier blueprint, which stop the push:"
+		if c.options.KeepServices {
+			heading = "Existing services from an earlier blueprint to reuse as they are:"
+		}
+		printServices(heading, stale)
+	}
+	printServices("Existing services to reuse:", reuse)
+	if len(drifted) > 0 {
+		fmt.Println(terminal.ColorizeBold("Existing services that differ from the manifest:", 31))
+		for _, instance := range drifted {
+			fmt.Printf("  %-20s has %s, manifest asks for %s\n", instance.name, instance.existing, instance)
+		}
+	}
+	pushArgs := append([]string{"cf", "push"}, args...)
+	fmt.Println(terminal.ColorizeBold("Manifest for "+strings.Join(pushArgs, " ")+":", 32))
+	return io.WriteYAML(os.Stdout, c.yamlMap)
+}
+
+func printServices(heading string, instances []*serviceInstance) {
+	fmt.Println(terminal.ColorizeBold(heading, 32))
+	if len(instances) == 0 {
+		fmt.Println("  none")
+	}
+	for _, instance := range instances {
+		fmt.Printf("  %-20s %s\n", instance.name, instance)
+	}
+}
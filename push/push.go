@@ -1,20 +1,20 @@
 package push
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
-	"github.com/cloudfoundry-community/brooklyn-plugin/assert"
 	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
 	"github.com/cloudfoundry-community/brooklyn-plugin/catalog"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
 	"github.com/cloudfoundry-community/brooklyn-plugin/io"
 	"github.com/cloudfoundry-community/brooklyn-plugin/sensors"
+	"github.com/cloudfoundry/cli/cf/errors"
 	"github.com/cloudfoundry/cli/cf/terminal"
 	"github.com/cloudfoundry/cli/generic"
 	"github.com/cloudfoundry/cli/plugin"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
@@ -24,6 +24,10 @@ type PushCommand struct {
 	ui            terminal.UI
 	yamlMap       generic.Map
 	credentials   *broker.BrokerCredentials
+	plan          *pushPlan
+	options       PushOptions
+	// catalog is the broker's catalog, fetched when first needed
+	catalog *broker.Catalog
 }
 
 func NewPushCommand(cliConnection plugin.CliConnection, ui terminal.UI, credentials *broker.BrokerCredentials) *PushCommand {
@@ -34,128 +38,273 @@ func NewPushCommand(cliConnection plugin.CliConnection, ui terminal.UI, credenti
 	return command
 }
 
+// PushOptions control how Push goes about pushing the app.
+type PushOptions struct {
+	// Manifest is the path of the manifest, by default manifest.yml.
+	Manifest string
+	// DryRun reports what would be done without doing it.
+	DryRun bool
+	// KeepServices reuses existing service instances whose blueprint has
+	// changed since they were created, which otherwise stop the push.
+	KeepServices bool
+	// Parallel is how many services to create, and then check on, at
+	// once, by default 4.
+	Parallel int
+	// Timeout is how long to wait for the services to be running, or 0
+	// to wait for as long as it takes.
+	Timeout time.Duration
+	// PollInterval is how long to wait before first checking on the
+	// services again, doubling each time up to MaxInterval.
+	PollInterval time.Duration
+	MaxInterval  time.Duration
+}
+
+// Defaults for the intervals between checks on the services.
+const (
+	DefaultPollInterval = 2 * time.Second
+	DefaultMaxInterval  = time.Minute
+)
+
 /*
-   modify the application manifest before passing to to original command
+modify the application manifest before passing to to original command,
+along with any other arguments given to push
 */
-func (c *PushCommand) Push(args []string) {
-	// args[0] == "push"
-
-	// TODO use CF way of parsing args
-	manifest := "manifest.yml"
-	if len(args) >= 3 && args[1] == "-f" {
-		manifest = args[2]
-		args = append(args[:1], args[3:]...)
+func (c *PushCommand) Push(args []string, options PushOptions) error {
+	if options.Parallel < 0 {
+		return failure.NewUsageError("--parallel cannot be negative")
+	}
+	if options.Timeout < 0 || options.PollInterval <= 0 || options.MaxInterval < options.PollInterval {
+		return failure.NewUsageError("--timeout cannot be negative, --poll-interval must be positive " +
+			"and --max-interval cannot be less than --poll-interval")
+	}
+	manifest := options.Manifest
+	if manifest == "" {
+		manifest = "manifest.yml"
+	}
+	yamlMap, err := io.ReadYAMLFile(manifest)
+	if err != nil {
+		return err
+	}
+	c.yamlMap = yamlMap
+	c.options = options
+	c.plan = new(pushPlan)
+
+	// work out what to create, rewriting the manifest to refer to the
+	// services by name
+	if _, err := c.replaceTopLevelServices(); err != nil {
+		return err
+	}
+	if _, err := c.replaceApplicationServices(); err != nil {
+		return err
 	}
-	c.yamlMap = io.ReadYAMLFile(manifest)
-
-	//fmt.Println("getting brooklyn")
-	allCreatedServices := []string{}
-	allCreatedServices = append(allCreatedServices, c.replaceTopLevelServices()...)
-	allCreatedServices = append(allCreatedServices, c.replaceApplicationServices()...)
 
-	for _, service := range allCreatedServices {
-		fmt.Printf("Waiting for %s to start...\n", service)
+	if options.DryRun {
+		return c.printPlan(args)
+	}
+	if err := c.executePlan(); err != nil {
+		return err
 	}
 
-	c.waitForServiceReady(allCreatedServices)
+	if started := c.plan.startedServiceNames(); len(started) > 0 {
+		fmt.Printf("Waiting for %s to start...\n", strings.Join(started, ", "))
+		if err := c.waitForServiceReady(started); err != nil {
+			return err
+		}
+	}
 
-	c.pushWith(args, "manifest.temp.yml")
+	return c.pushWith(args, "manifest.temp.yml")
 }
 
-func (c *PushCommand) waitForServiceReady(services []string) {
+func (c *PushCommand) waitForServiceReady(services []string) error {
 	// before pushing check to see if service is running
 
-	ready := c.allReady(services)
-	waitTime := 2 * time.Second
-	for !ready {
+	// requests still running at the deadline are abandoned, so that a
+	// hung broker cannot hold up the push for longer than the timeout
+	ctx := context.Background()
+	if c.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.Timeout)
+		defer cancel()
+	}
+	client := broker.NewClient(c.cliConnection, c.credentials).WithContext(ctx)
+	guids := make(map[string]string)
+	// look up everything that needs the CLI once, before the services
+	// are checked on concurrently
+	err := untilDone(ctx, func() error {
+		if _, err := client.BrokerUrl(); err != nil {
+			return err
+		}
+		for _, service := range services {
+			guid, err := broker.ServiceGuid(c.cliConnection, service)
+			if err != nil {
+				return err
+			}
+			guids[service] = guid
+		}
+		return nil
+	})
+	if err != nil {
+		return c.timedOut(ctx, services, err)
+	}
+	pending, err := c.notReady(client, guids, services)
+	waitTime := c.options.PollInterval
+	for err == nil && len(pending) > 0 {
+		fmt.Printf("%d of %d services running, waiting for %s\n",
+			len(services)-len(pending), len(services), strings.Join(pending, ", "))
+		if deadline, found := ctx.Deadline(); found {
+			remaining := deadline.Sub(time.Now())
+			if remaining <= 0 {
+				return c.timedOut(ctx, pending, ctx.Err())
+			}
+			if waitTime > remaining {
+				waitTime = remaining
+			}
+		}
 		fmt.Printf("Trying again in %v\n", waitTime)
 		time.Sleep(waitTime)
-		ready = c.allReady(services)
-		if 2*waitTime == 16*time.Second {
-			waitTime = 15 * time.Second
-		} else if 2*waitTime > time.Minute {
-			waitTime = time.Minute
-		} else {
-			waitTime = 2 * waitTime
+		pending, err = c.notReady(client, guids, pending)
+		waitTime = 2 * waitTime
+		if waitTime > c.options.MaxInterval {
+			waitTime = c.options.MaxInterval
 		}
 	}
+	return c.timedOut(ctx, pending, err)
 }
 
-func (c *PushCommand) allReady(services []string) bool {
-	ready := true
-	for _, service := range services {
-		serviceReady := sensors.NewSensorCommand(c.cliConnection, c.ui).IsServiceReady(c.credentials, service)
-		if !serviceReady {
-			fmt.Printf("%s is not yet running.\n", service)
+// timedOut reports err as a timeout if it came from the deadline of ctx
+// passing while waiting for the services.
+func (c *PushCommand) timedOut(ctx context.Context, services []string, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return failure.NewTimeoutError("gave up after %v waiting for services that never became ready: %s",
+			c.options.Timeout, strings.Join(services, ", "))
+	}
+	return err
+}
+
+// untilDone runs fn, which may call the CLI and so cannot be cancelled,
+// returning early with the error of ctx if it is done first.
+func untilDone(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notReady checks the services concurrently and returns those not yet
+// running, in the order given.
+func (c *PushCommand) notReady(client *broker.Client, guids map[string]string, services []string) ([]string, error) {
+	ready := make([]bool, len(services))
+	err := forEach(len(services), c.options.Parallel, func(i int) error {
+		guid := guids[services[i]]
+		var err error
+		if ready[i], err = client.IsRunning(guid); err != nil || ready[i] {
+			return err
+		}
+		// a failed deployment never becomes ready, so stop waiting; the
+		// sensors may not be published yet, which is no reason to stop
+		tree, err := client.Sensors(guid)
+		if state := sensors.ServiceState(tree); err == nil && sensors.IsOnFire(state) {
+			return fmt.Errorf("service %s failed to start and is %s, see cf brooklyn sensors %s for its problems",
+				services[i], state, services[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pending := []string{}
+	for i, service := range services {
+		if !ready[i] {
+			pending = append(pending, service)
 		}
-		ready = ready && serviceReady
 	}
-	return ready
+	return pending, nil
 }
 
-func (c *PushCommand) pushWith(args []string, tempFile string) {
-	io.WriteYAMLFile(c.yamlMap, tempFile)
-	_, err := c.cliConnection.CliCommand(append(args, "-f", tempFile)...)
-	assert.ErrorIsNil(err)
-	err = os.Remove(tempFile)
-	assert.ErrorIsNil(err)
+func (c *PushCommand) pushWith(args []string, tempFile string) error {
+	if err := io.WriteYAMLFile(c.yamlMap, tempFile); err != nil {
+		return err
+	}
+	defer os.Remove(tempFile)
+	pushArgs := append([]string{"push"}, args...)
+	_, err := c.cliConnection.CliCommand(append(pushArgs, "-f", tempFile)...)
+	return err
 }
 
-func (c *PushCommand) replaceTopLevelServices() []string {
+func (c *PushCommand) replaceTopLevelServices() ([]string, error) {
 	allCreatedServices := []string{}
 	services, found := c.yamlMap.Get("services").([]interface{})
 	if !found {
-		return allCreatedServices
+		return allCreatedServices, nil
 	}
 	for i, service := range services {
 		switch service.(type) {
 		case string: // do nothing, since service is an existing named service
 		case map[interface{}]interface{}:
-			createdService := c.newServiceFromMap(service.(map[interface{}]interface{}))
+			createdService, err := c.newServiceFromMap(service.(map[interface{}]interface{}))
+			if err != nil {
+				return nil, err
+			}
 			allCreatedServices = append(allCreatedServices, createdService)
 			// replace the defn in the yaml for its name
 			services[i] = createdService
 		}
 	}
-	return allCreatedServices
+	return allCreatedServices, nil
 }
 
-func (c *PushCommand) replaceApplicationServices() []string {
+func (c *PushCommand) replaceApplicationServices() ([]string, error) {
 	allCreatedServices := []string{}
 	applications, found := c.yamlMap.Get("applications").([]interface{})
 	if !found {
-		return allCreatedServices
+		return allCreatedServices, nil
 	}
 	for _, app := range applications {
 		application, found := app.(map[interface{}]interface{})
-		assert.Condition(found, "Application not found.")
-		allCreatedServices = append(allCreatedServices, c.replaceBrooklynCreatingServices(application)...)
-		allCreatedServices = append(allCreatedServices, c.replaceServicesCreatingServices(application)...)
+		if !found {
+			return nil, errors.New("Application not found.")
+		}
+		createdServices, err := c.replaceBrooklynCreatingServices(application)
+		if err != nil {
+			return nil, err
+		}
+		allCreatedServices = append(allCreatedServices, createdServices...)
+		createdServices, err = c.replaceServicesCreatingServices(application)
+		if err != nil {
+			return nil, err
+		}
+		allCreatedServices = append(allCreatedServices, createdServices...)
 	}
 
-	return allCreatedServices
+	return allCreatedServices, nil
 }
 
-func (c *PushCommand) replaceBrooklynCreatingServices(application map[interface{}]interface{}) []string {
+func (c *PushCommand) replaceBrooklynCreatingServices(application map[interface{}]interface{}) ([]string, error) {
 	brooklyn, found := application["brooklyn"].([]interface{})
 	var createdServices []string
 	if !found {
-		return createdServices
+		return createdServices, nil
+	}
+	createdServices, err := c.createAllServicesFromBrooklyn(brooklyn)
+	if err != nil {
+		return nil, err
 	}
-	createdServices = c.createAllServicesFromBrooklyn(brooklyn)
 	application["services"] = c.mergeServices(application, createdServices)
 	delete(application, "brooklyn")
-	return createdServices
+	return createdServices, nil
 }
 
-func (c *PushCommand) replaceServicesCreatingServices(application map[interface{}]interface{}) []string {
+func (c *PushCommand) replaceServicesCreatingServices(application map[interface{}]interface{}) ([]string, error) {
 	services, found := application["services"].([]interface{})
 	createdServices := []string{}
 	if !found {
-		return createdServices
+		return createdServices, nil
 	}
-	createdServices = c.createAllServicesFromServices(services)
-	return createdServices
+	return c.createAllServicesFromServices(services)
 }
 
 func (c *PushCommand) mergeServices(application map[interface{}]interface{}, services []string) []string {
@@ -167,121 +316,126 @@ func (c *PushCommand) mergeServices(application map[interface{}]interface{}, ser
 	return services
 }
 
-func (c *PushCommand) createAllServicesFromServices(services []interface{}) []string {
+func (c *PushCommand) createAllServicesFromServices(services []interface{}) ([]string, error) {
 	var createdServices []string
 	for i, service := range services {
 		switch service.(type) {
 		case string: // do nothing, since service is an existing named service
 		case map[interface{}]interface{}:
 			// service definition
-			createdService := c.newServiceFromMap(service.(map[interface{}]interface{}))
+			createdService, err := c.newServiceFromMap(service.(map[interface{}]interface{}))
+			if err != nil {
+				return nil, err
+			}
 			createdServices = append(createdServices, createdService)
 			services[i] = createdService
 		}
 	}
-	return createdServices
+	return createdServices, nil
 }
 
-func (c *PushCommand) createAllServicesFromBrooklyn(brooklyn []interface{}) []string {
+func (c *PushCommand) createAllServicesFromBrooklyn(brooklyn []interface{}) ([]string, error) {
 	services := []string{}
 	for _, brooklynApp := range brooklyn {
 		brooklynApplication, found := brooklynApp.(map[interface{}]interface{})
-		assert.Condition(found, "Expected Map.")
-		services = append(services, c.newService(brooklynApplication))
+		if !found {
+			return nil, errors.New("Expected Map.")
+		}
+		service, err := c.newService(brooklynApplication)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, service)
 	}
-	return services
+	return services, nil
 }
 
-func (c *PushCommand) newServiceFromMap(service map[interface{}]interface{}) string {
+func (c *PushCommand) newServiceFromMap(service map[interface{}]interface{}) (string, error) {
 	name, found := service["name"].(string)
-	assert.Condition(found, "no name specified in blueprint")
+	if !found {
+		return "", errors.New("no name specified in blueprint")
+	}
 	location, found := service["location"].(string)
-	assert.Condition(found, "no location specified")
-	if exists := c.catalogItemExists(name); !exists {
-		c.createNewCatalogItemWithoutLocation(name, []interface{}{service})
+	if !found {
+		return "", errors.New("no location specified")
 	}
-	c.cliConnection.CliCommand("create-service", name, location, name)
-	return name
+	if err := c.planCatalogItem(name, []interface{}{service}, nil); err != nil {
+		return "", err
+	}
+	return name, c.planService(name, location, name)
 }
 
 // expects an item from the brooklyn section with a name section
-func (c *PushCommand) newService(brooklynApplication map[interface{}]interface{}) string {
+func (c *PushCommand) newService(brooklynApplication map[interface{}]interface{}) (string, error) {
 	name, found := brooklynApplication["name"].(string)
-	assert.Condition(found, "Expected Name.")
-	c.createServices(brooklynApplication, name)
-	return name
+	if !found {
+		return "", errors.New("Expected Name.")
+	}
+	return name, c.createServices(brooklynApplication, name)
 }
 
 // expects an item from the brooklyn section
-func (c *PushCommand) createServices(brooklynApplication map[interface{}]interface{}, name string) {
+func (c *PushCommand) createServices(brooklynApplication map[interface{}]interface{}, name string) error {
 	// If there is a service section then this refers to an
 	// existing catalog entry.
 	service, found := brooklynApplication["service"].(string)
 	if found {
 		// now we must use an existing plan (location)
 		location, found := brooklynApplication["location"].(string)
-		assert.Condition(found, "Expected Location")
-		c.cliConnection.CliCommand("create-service", service, location, name)
-	} else {
-		c.extractAndCreateService(brooklynApplication, name)
+		if !found {
+			return errors.New("Expected Location")
+		}
+		return c.planService(service, location, name)
 	}
+	return c.extractAndCreateService(brooklynApplication, name)
 }
 
-func (c *PushCommand) extractAndCreateService(brooklynApplication map[interface{}]interface{}, name string) {
+func (c *PushCommand) extractAndCreateService(brooklynApplication map[interface{}]interface{}, name string) error {
 	// If there is a services section then this is a blueprint
 	// and this should be extracted and sent as a catalog item
 	blueprints, found := brooklynApplication["services"].([]interface{})
 	var location string
-	if found {
+	if !found {
+		return nil
+	}
 
-		// only do this if catalog doesn't contain it already
-		// now we decide whether to add a location to the
-		// catalog item, or use all locations as plans
-		switch brooklynApplication["location"].(type) {
-		case string:
-			location = brooklynApplication["location"].(string)
-			if exists := c.catalogItemExists(name); !exists {
-				c.createNewCatalogItemWithoutLocation(name, blueprints)
-			}
-		case map[interface{}]interface{}:
-			locationMap := brooklynApplication["location"].(map[interface{}]interface{})
-			count := 0
-			for key, _ := range locationMap {
-				location, found = key.(string)
-				assert.Condition(found, "location not found")
-				count = count + 1
-			}
-			assert.Condition(count == 1, "Expected only one location")
-			if exists := c.catalogItemExists(name); !exists {
-				c.createNewCatalogItemWithLocation(name, blueprints, locationMap)
+	// only do this if catalog doesn't contain it already, or holds an
+	// older version of the blueprint
+	// now we decide whether to add a location to the
+	// catalog item, or use all locations as plans
+	switch brooklynApplication["location"].(type) {
+	case string:
+		location = brooklynApplication["location"].(string)
+		if err := c.planCatalogItem(name, blueprints, nil); err != nil {
+			return err
+		}
+	case map[interface{}]interface{}:
+		locationMap := brooklynApplication["location"].(map[interface{}]interface{})
+		count := 0
+		for key, _ := range locationMap {
+			location, found = key.(string)
+			if !found {
+				return errors.New("location not found")
 			}
+			count = count + 1
 		}
-		c.cliConnection.CliCommand("create-service", name, location, name)
-	}
-}
-
-func (c *PushCommand) catalogItemExists(name string) bool {
-	services, err := c.cliConnection.CliCommandWithoutTerminalOutput("marketplace", "-s", name)
-	if err != nil {
-		return false
-	}
-
-	for _, a := range services {
-		fields := strings.Fields(a)
-		if fields[0] == "OK" {
-			return true
+		if count != 1 {
+			return errors.New("Expected only one location")
+		}
+		if err := c.planCatalogItem(name, blueprints, locationMap); err != nil {
+			return err
 		}
 	}
-	return false
+	return c.planService(name, location, name)
 }
 
-func (c *PushCommand) createCatalogYamlMap(name string, blueprintMap []interface{}) generic.Map {
+func (c *PushCommand) createCatalogYamlMap(name, version, description string, blueprintMap []interface{}) generic.Map {
 	yamlMap := generic.NewMap()
 	entry := map[string]string{
 		"id":          name,
-		"version":     "1.0",
+		"version":     version,
 		"iconUrl":     "",
-		"description": "A user defined blueprint",
+		"description": description,
 	}
 	yamlMap.Set("brooklyn.catalog", entry)
 	yamlMap.Set("name", name)
@@ -295,50 +449,36 @@ func (c *PushCommand) createCatalogYamlMap(name string, blueprintMap []interface
 	return yamlMap
 }
 
-func (c *PushCommand) createNewCatalogItemWithLocation(
-	name string, blueprintMap []interface{}, location map[interface{}]interface{}) {
-	yamlMap := c.createCatalogYamlMap(name, blueprintMap)
-	yamlMap.Set("location", generic.NewMap(location))
-	c.createNewCatalogItem(name, yamlMap)
-}
-
-func (c *PushCommand) createNewCatalogItemWithoutLocation(name string, blueprintMap []interface{}) {
-	yamlMap := c.createCatalogYamlMap(name, blueprintMap)
-	c.createNewCatalogItem(name, yamlMap)
-}
-
-func (c *PushCommand) createNewCatalogItem(name string, yamlMap generic.Map) {
+func (c *PushCommand) createNewCatalogItem(name string, yamlMap generic.Map) error {
 	tempFile := "catalog.temp.yml"
-	io.WriteYAMLFile(yamlMap, tempFile)
+	if err := io.WriteYAMLFile(yamlMap, tempFile); err != nil {
+		return err
+	}
+	defer os.Remove(tempFile)
 
 	cred := c.credentials
 	brokerUrl, err := broker.ServiceBrokerUrl(c.cliConnection, cred.Broker)
-	assert.ErrorIsNil(err)
-
-	catalog.NewAddCatalogCommand(c.cliConnection, c.ui).AddCatalog(cred, tempFile)
-
-	c.cliConnection.CliCommand("update-service-broker", cred.Broker, cred.Username, cred.Password, brokerUrl)
-	c.cliConnection.CliCommand("enable-service-access", name)
-	err = os.Remove(tempFile)
-	assert.ErrorIsNil(err)
-}
-
-func (c *PushCommand) addCatalog(cred *broker.BrokerCredentials, filePath string) {
-	fmt.Println("Adding Brooklyn catalog item...")
+	if err != nil {
+		return err
+	}
 
-	file, err := os.Open(filepath.Clean(filePath))
-	assert.ErrorIsNil(err)
-	defer file.Close()
+	err = catalog.NewAddCatalogCommand(c.cliConnection, c.ui).AddCatalog(cred, tempFile)
+	if err != nil {
+		return err
+	}
 
-	req, err := http.NewRequest("POST", broker.CreateRestCallUrlString(c.cliConnection, cred, "create"), file)
-	assert.ErrorIsNil(err)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	broker.SendRequest(req)
+	_, err = c.cliConnection.CliCommand("update-service-broker", cred.Broker, cred.Username, cred.Password, brokerUrl)
+	if err != nil {
+		return err
+	}
+	_, err = c.cliConnection.CliCommand("enable-service-access", name)
+	return err
 }
 
-func (c *PushCommand) randomString(size int) string {
+func (c *PushCommand) randomString(size int) (string, error) {
 	rb := make([]byte, size)
-	_, err := rand.Read(rb)
-	assert.ErrorIsNil(err)
-	return base64.URLEncoding.EncodeToString(rb)
+	if _, err := rand.Read(rb); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(rb), nil
 }
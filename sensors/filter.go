@@ -0,0 +1,87 @@
+package sensors
+
+import (
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter selects the entities and sensors to list. Entity is a glob
+// matched against entity paths such as app/cluster/node-1, and the
+// descendants of matching entities are included too. Sensor is a glob
+// matched against sensor names, or a regular expression if written
+// between slashes, as in /^host\./.
+type Filter struct {
+	Entity string
+	Sensor string
+}
+
+func (f Filter) isEmpty() bool {
+	return f.Entity == "" && f.Sensor == ""
+}
+
+// apply returns a copy of the tree holding only the selected entities
+// and sensors, along with the ancestors needed to reach them.
+func (f Filter) apply(tree broker.SensorTree) (broker.SensorTree, error) {
+	if f.Entity != "" {
+		if _, err := path.Match(f.Entity, ""); err != nil {
+			return nil, failure.NewUsageError("invalid entity pattern %q: %s", f.Entity, err)
+		}
+	}
+	matchSensor, err := f.sensorMatcher()
+	if err != nil {
+		return nil, err
+	}
+	return f.filterTree(tree, nil, f.Entity == "", matchSensor), nil
+}
+
+func (f Filter) filterTree(tree broker.SensorTree, parent []string, entityMatched bool,
+	matchSensor func(string) bool) broker.SensorTree {
+
+	filtered := make(broker.SensorTree)
+	for name, entity := range tree {
+		entityPath := append(append([]string{}, parent...), name)
+		matched := entityMatched
+		if !matched {
+			matched, _ = path.Match(f.Entity, strings.Join(entityPath, "/"))
+		}
+		kept := &broker.EntitySensors{
+			Sensors:  make(map[string]interface{}),
+			Children: f.filterTree(entity.Children, entityPath, matched, matchSensor),
+		}
+		if matched {
+			for k, v := range entity.Sensors {
+				if matchSensor(k) {
+					kept.Sensors[k] = v
+				}
+			}
+		}
+		if len(kept.Sensors) > 0 || len(kept.Children) > 0 {
+			filtered[name] = kept
+		}
+	}
+	return filtered
+}
+
+func (f Filter) sensorMatcher() (func(string) bool, error) {
+	pattern := f.Sensor
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, failure.NewUsageError("invalid sensor expression %q: %s", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, failure.NewUsageError("invalid sensor pattern %q: %s", pattern, err)
+	}
+	return func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}, nil
+}
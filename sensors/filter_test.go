@@ -0,0 +1,88 @@
+package sensors
+
+import (
+	"encoding/json"
+	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const testSensors = `{
+	"app": {
+		"service.state": "running",
+		"children": {
+			"cluster": {
+				"group.members": 2,
+				"children": {
+					"node-1": {"host.name": "a", "webapp.url": "http://a"},
+					"node-2": {"host.name": "b", "webapp.url": "http://b"}
+				}
+			}
+		}
+	}
+}`
+
+// sensorPaths lists the entity/sensor pairs left in the tree.
+func sensorPaths(tree broker.SensorTree) []string {
+	paths := []string{}
+	tree.Walk(func(path []string, entity *broker.EntitySensors) {
+		for name := range entity.Sensors {
+			paths = append(paths, joinPath(path)+" "+name)
+		}
+	})
+	sort.Strings(paths)
+	return paths
+}
+
+func joinPath(path []string) string {
+	joined := path[0]
+	for _, name := range path[1:] {
+		joined += "/" + name
+	}
+	return joined
+}
+
+func TestFilterApply(t *testing.T) {
+	var tree broker.SensorTree
+	if err := json.Unmarshal([]byte(testSensors), &tree); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		filter Filter
+		want   []string
+	}{
+		{Filter{Entity: "app"}, []string{
+			"app service.state", "app/cluster group.members",
+			"app/cluster/node-1 host.name", "app/cluster/node-1 webapp.url",
+			"app/cluster/node-2 host.name", "app/cluster/node-2 webapp.url",
+		}},
+		{Filter{Entity: "app/cluster/node-*"}, []string{
+			"app/cluster/node-1 host.name", "app/cluster/node-1 webapp.url",
+			"app/cluster/node-2 host.name", "app/cluster/node-2 webapp.url",
+		}},
+		{Filter{Sensor: "host.*"}, []string{"app/cluster/node-1 host.name", "app/cluster/node-2 host.name"}},
+		{Filter{Sensor: "/^(service|group)\\./"}, []string{"app service.state", "app/cluster group.members"}},
+		{Filter{Entity: "app/cluster/node-2", Sensor: "webapp.*"}, []string{"app/cluster/node-2 webapp.url"}},
+		{Filter{Entity: "nothing"}, []string{}},
+	}
+	for _, test := range tests {
+		filtered, err := test.filter.apply(tree)
+		if err != nil {
+			t.Errorf("%+v.apply failed: %s", test.filter, err)
+			continue
+		}
+		if got := sensorPaths(filtered); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v.apply kept %q, want %q", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestFilterApplyErrors(t *testing.T) {
+	for _, filter := range []Filter{{Entity: "app/["}, {Sensor: "host.["}, {Sensor: "/(/"}} {
+		if _, err := filter.apply(broker.SensorTree{}); !failure.IsUsageError(err) {
+			t.Errorf("%+v.apply returned %v, want a usage error", filter, err)
+		}
+	}
+}
@@ -1,19 +1,26 @@
 package sensors
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"github.com/cloudfoundry-community/brooklyn-plugin/assert"
 	"github.com/cloudfoundry-community/brooklyn-plugin/broker"
+	"github.com/cloudfoundry-community/brooklyn-plugin/failure"
+	"github.com/cloudfoundry-community/brooklyn-plugin/output"
+	"github.com/cloudfoundry-community/brooklyn-plugin/tree"
 	"github.com/cloudfoundry/cli/cf/terminal"
 	"github.com/cloudfoundry/cli/plugin"
-	"net/http"
-	"strconv"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"time"
 )
 
 type SensorCommand struct {
 	cliConnection plugin.CliConnection
 	ui            terminal.UI
+	// sensor values from the previous poll when watching
+	previous map[string]interface{}
 }
 
 func NewSensorCommand(cliConnection plugin.CliConnection, ui terminal.UI) *SensorCommand {
@@ -22,72 +29,256 @@ func NewSensorCommand(cliConnection plugin.CliConnection, ui terminal.UI) *Senso
 	return command
 }
 
-func (c *SensorCommand) getSensors(cred *broker.BrokerCredentials, service string) map[string]interface{} {
-	guid, err := c.cliConnection.CliCommandWithoutTerminalOutput("service", service, "--guid")
-	url := broker.CreateRestCallUrlString(c.cliConnection, cred, "sensors/"+guid[0])
-	req, err := http.NewRequest("GET", url, nil)
-	assert.ErrorIsNil(err)
-	body, _ := broker.SendRequest(req)
-	//fmt.Println(string(body))
-	var sensors map[string]interface{}
-	err = json.Unmarshal(body, &sensors)
-	assert.ErrorIsNil(err)
-	return sensors
+func (c *SensorCommand) getSensors(cred *broker.BrokerCredentials, service string) (broker.SensorTree, error) {
+	guid, err := broker.ServiceGuid(c.cliConnection, service)
+	if err != nil {
+		return nil, err
+	}
+	return broker.NewClient(c.cliConnection, cred).Sensors(guid)
 }
 
-func (c *SensorCommand) IsServiceReady(cred *broker.BrokerCredentials, service string) bool {
-	guid, err := c.cliConnection.CliCommandWithoutTerminalOutput("service", service, "--guid")
-	url := broker.CreateRestCallUrlString(c.cliConnection, cred, "is-running/"+guid[0])
-	req, err := http.NewRequest("GET", url, nil)
-	assert.ErrorIsNil(err)
-	body, _ := broker.SendRequest(req)
-	//fmt.Println("is running = ", string(body))
-	b, err := strconv.ParseBool(string(body))
-	return b
+func (c *SensorCommand) IsServiceReady(cred *broker.BrokerCredentials, service string) (bool, error) {
+	guid, err := broker.ServiceGuid(c.cliConnection, service)
+	if err != nil {
+		return false, err
+	}
+	return broker.NewClient(c.cliConnection, cred).IsRunning(guid)
 }
 
-func (c *SensorCommand) ListSensors(cred *broker.BrokerCredentials, service string) {
-	sensors := c.getSensors(cred, service)
-	fmt.Println(terminal.ColorizeBold(service, 32))
-	for i := 0; i < len(service); i++ {
-		fmt.Print(terminal.ColorizeBold("-", 32))
+// ServiceState returns the Brooklyn lifecycle state of a service from
+// the service.state sensor of its top level entities, preferring
+// on-fire if any of them is, or "" if none publishes it yet.
+func ServiceState(tree broker.SensorTree) string {
+	state := ""
+	for _, entity := range tree {
+		value, _ := entity.Sensors["service.state"].(string)
+		if IsOnFire(value) {
+			return value
+		}
+		if state == "" {
+			state = value
+		}
 	}
-	fmt.Println()
-	c.outputSensorChildren(0, sensors)
+	return state
 }
 
-func (c *SensorCommand) outputSensorChildren(indent int, sensors map[string]interface{}) {
-	for k, v := range sensors {
-		c.printIndent(indent)
-		if indent == 0 {
-			fmt.Print(terminal.ColorizeBold("Entity:", 32))
-		}
-		fmt.Println(terminal.ColorizeBold(k, 32))
-		c.outputSensors(indent+1, v.(map[string]interface{}))
+// IsOnFire reports whether a service.state value, written on-fire or
+// ON_FIRE depending on the Brooklyn version, means the service failed.
+func IsOnFire(state string) bool {
+	return strings.EqualFold(strings.Replace(state, "-", "_", -1), "ON_FIRE")
+}
+
+// ListOptions control how ListSensors presents the sensors.
+type ListOptions struct {
+	// Output is the format to print the sensors in, by default a tree.
+	Output string
+	Filter Filter
+	// Tree controls how the tree is rendered.
+	Tree tree.Options
+}
+
+func (c *SensorCommand) ListSensors(cred *broker.BrokerCredentials, service string, options ListOptions) error {
+	guid, err := broker.ServiceGuid(c.cliConnection, service)
+	if err != nil {
+		return err
+	}
+	sensors, err := filteredSensors(broker.NewClient(c.cliConnection, cred), guid, options.Filter)
+	if err != nil {
+		return err
+	}
+	if output.IsMachineReadable(options.Output) {
+		return output.Write(os.Stdout, options.Output, sensors)
 	}
+	c.printSensorTree(service, sensors, options.Tree)
+	return nil
 }
 
-func (c *SensorCommand) outputSensors(indent int, sensors map[string]interface{}) {
-	children := sensors["children"]
-	for k, v := range sensors {
-		if k != "children" {
-			c.printIndent(indent)
-			switch v.(type) {
-			default:
-				fmt.Println(k, ":", v)
-			case map[string]interface{}:
-				fmt.Println(k)
-				c.outputSensors(indent+1, v.(map[string]interface{}))
+// filteredSensors fetches the sensors of the service instance with the
+// given guid, keeping only those the filter selects.
+func filteredSensors(client *broker.Client, guid string, filter Filter) (broker.SensorTree, error) {
+	sensors, err := client.Sensors(guid)
+	if err != nil || filter.isEmpty() {
+		return sensors, err
+	}
+	return filter.apply(sensors)
+}
+
+// WatchSensors lists the sensors repeatedly, redrawing the tree in place
+// and highlighting the values that changed since the previous poll,
+// until interrupted. Only an error on the first poll stops it; later
+// ones are shown in place of the tree.
+func (c *SensorCommand) WatchSensors(cred *broker.BrokerCredentials, service string, options ListOptions, interval time.Duration) error {
+	if output.IsMachineReadable(options.Output) {
+		return failure.NewUsageError("--watch cannot be used with --output")
+	}
+	if interval <= 0 {
+		return failure.NewUsageError("--interval must be positive")
+	}
+	// look up everything that needs the CLI once, rather than on every
+	// poll
+	guid, err := broker.ServiceGuid(c.cliConnection, service)
+	if err != nil {
+		return err
+	}
+	client := broker.NewClient(c.cliConnection, cred)
+	if _, err := client.BrokerUrl(); err != nil {
+		return err
+	}
+	// Ctrl-C also abandons a request the broker is slow to answer
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client = client.WithContext(interrupted)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sensors, err := filteredSensors(client, guid, options.Filter)
+		if interrupted.Err() != nil {
+			fmt.Println()
+			return nil
+		}
+		if err != nil && c.previous == nil {
+			return err
+		}
+		fmt.Print(clearScreen)
+		if err != nil {
+			// the broker may be restarting, so try again next time
+			fmt.Println("Could not get the sensors:", err)
+		} else {
+			current := flattenSensors(sensors)
+			if c.previous == nil {
+				// nothing is highlighted on the first poll
+				c.previous = current
 			}
+			c.printSensorTree(service, sensors, options.Tree)
+			c.previous = current
+		}
+		fmt.Printf("\nUpdated %s, every %v. Press Ctrl-C to stop.\n", time.Now().Format("15:04:05"), interval)
+
+		select {
+		case <-interrupted.Done():
+			fmt.Println()
+			return nil
+		case <-ticker.C:
 		}
 	}
-	if children != nil {
-		c.outputSensorChildren(indent+1, children.(map[string]interface{}))
+}
+
+const clearScreen = "\033[H\033[2J"
+
+func (c *SensorCommand) printSensorTree(service string, sensors broker.SensorTree, options tree.Options) {
+	options.Separator = " : "
+	tree.Render(os.Stdout, service, c.sensorNodes(1, nil, sensors, options), options)
+}
+
+// flattenSensors maps a key made from the entity path and sensor name
+// to every sensor value in the tree, so values can be compared between
+// polls.
+func flattenSensors(sensors broker.SensorTree) map[string]interface{} {
+	flat := make(map[string]interface{})
+	sensors.Walk(func(path []string, entity *broker.EntitySensors) {
+		flattenValues(flat, sensorKey(path), entity.Sensors)
+	})
+	return flat
+}
+
+func flattenValues(flat map[string]interface{}, prefix string, values map[string]interface{}) {
+	for k, v := range values {
+		if nested, isMap := v.(map[string]interface{}); isMap {
+			flattenValues(flat, prefix+"/"+k, nested)
+		} else {
+			flat[prefix+"/"+k] = v
+		}
+	}
+}
+
+func sensorKey(path []string) string {
+	return strings.Join(path, "/")
+}
+
+// changed reports whether the value differs from the previous poll.
+func (c *SensorCommand) changed(key string, value interface{}) bool {
+	if c.previous == nil {
+		return false
+	}
+	previous, found := c.previous[key]
+	return !found || !reflect.DeepEqual(previous, value)
+}
+
+// PrintSensor prints the value of a single sensor, on its own for use
+// in scripts. The entity is given by its path, such as app/cluster/node-1,
+// or by its name alone if no other entity has that name.
+func (c *SensorCommand) PrintSensor(cred *broker.BrokerCredentials, service, entity, sensor string) error {
+	sensors, err := c.getSensors(cred, service)
+	if err != nil {
+		return err
+	}
+	found, err := findEntity(sensors, entity)
+	if err != nil {
+		return err
+	}
+	value, has := found.Sensors[sensor]
+	if !has {
+		return fmt.Errorf("entity %s of service %s has no sensor %s", entity, service, sensor)
 	}
+	if text, isString := value.(string); isString {
+		fmt.Println(text)
+		return nil
+	}
+	return output.Write(os.Stdout, output.JSON, value)
+}
+
+func findEntity(sensors broker.SensorTree, entity string) (*broker.EntitySensors, error) {
+	var byPath *broker.EntitySensors
+	var byName []*broker.EntitySensors
+	sensors.Walk(func(path []string, e *broker.EntitySensors) {
+		if strings.Join(path, "/") == entity {
+			byPath = e
+		}
+		if path[len(path)-1] == entity {
+			byName = append(byName, e)
+		}
+	})
+	switch {
+	case byPath != nil:
+		return byPath, nil
+	case len(byName) == 1:
+		return byName[0], nil
+	case len(byName) > 1:
+		return nil, failure.NewUsageError("more than one entity is named %s, give its path instead", entity)
+	}
+	return nil, fmt.Errorf("no entity %s", entity)
+}
+
+// sensorNodes builds the tree of entities and their sensors, down to
+// the depth chosen in the options.
+func (c *SensorCommand) sensorNodes(depth int, path []string, sensors broker.SensorTree, options tree.Options) []*tree.Node {
+	var nodes []*tree.Node
+	for k, v := range sensors {
+		label := k
+		if depth == 1 {
+			label = "Entity:" + k
+		}
+		entityPath := append(append([]string{}, path...), k)
+		node := tree.Branch(label, tree.Green, c.valueNodes(sensorKey(entityPath), v.Sensors)...)
+		if v.Children != nil && options.ShowDepth(depth+1) {
+			node.Children = append(node.Children, c.sensorNodes(depth+1, entityPath, v.Children, options)...)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
 }
 
-func (c *SensorCommand) printIndent(indent int) {
-	for i := 0; i < indent; i++ {
-		fmt.Print("  ")
+func (c *SensorCommand) valueNodes(prefix string, sensors map[string]interface{}) []*tree.Node {
+	var nodes []*tree.Node
+	for k, v := range sensors {
+		if nested, isMap := v.(map[string]interface{}); isMap {
+			nodes = append(nodes, tree.Branch(k, 0, c.valueNodes(prefix+"/"+k, nested)...))
+			continue
+		}
+		node := tree.Leaf(k, fmt.Sprint(v))
+		node.Highlight = c.changed(prefix+"/"+k, v)
+		nodes = append(nodes, node)
 	}
+	return nodes
 }
@@ -0,0 +1,128 @@
+package tree
+
+import (
+	"fmt"
+	"github.com/cloudfoundry/cli/cf/terminal"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Colors used when rendering, as understood by terminal.ColorizeBold.
+const (
+	Red    = 31
+	Green  = 32
+	Yellow = 33
+	Cyan   = 36
+)
+
+// Options control how a tree is rendered.
+type Options struct {
+	// NoColor turns off colored and bold output.
+	NoColor bool
+	// Depth is how many levels of entities to show, or 0 for all of them.
+	Depth int
+	// Separator is written between a key and its value.
+	Separator string
+}
+
+// ShowDepth reports whether entities depth levels down, counting the
+// top level as 1, are shown.
+func (o Options) ShowDepth(depth int) bool {
+	return o.Depth <= 0 || depth <= o.Depth
+}
+
+// Node is a line of a tree: a label, possibly with a value, and the
+// nodes nested under it.
+type Node struct {
+	Label    string
+	Value    string
+	HasValue bool
+	// Color is applied to the label, unless 0.
+	Color int
+	// Highlight shows the whole line in bold yellow, or marks it with a
+	// trailing * when colors are off.
+	Highlight bool
+	// Ordered keeps the children in the order given rather than sorting
+	// them.
+	Ordered  bool
+	Children []*Node
+}
+
+func Branch(label string, color int, children ...*Node) *Node {
+	return &Node{Label: label, Color: color, Children: children}
+}
+
+func Leaf(label, value string) *Node {
+	return &Node{Label: label, Value: value, HasValue: true}
+}
+
+// Render writes the title, underlined, followed by the nodes. Siblings
+// are sorted by label, those with values first, and the values of
+// siblings are aligned in a column.
+func Render(w io.Writer, title string, nodes []*Node, options Options) {
+	fmt.Fprintln(w, options.colorize(title, Green))
+	fmt.Fprintln(w, options.colorize(strings.Repeat("-", len(title)), Green))
+	options.render(w, 0, nodes, false)
+}
+
+func (o Options) render(w io.Writer, indent int, nodes []*Node, ordered bool) {
+	if !ordered {
+		nodes = sorted(nodes)
+	}
+	width := 0
+	for _, node := range nodes {
+		if node.HasValue && len(node.Label) > width {
+			width = len(node.Label)
+		}
+	}
+	for _, node := range nodes {
+		fmt.Fprint(w, strings.Repeat("  ", indent))
+		switch {
+		case node.Highlight && o.NoColor:
+			fmt.Fprintln(w, o.line(node, width)+" *")
+		case node.Highlight:
+			fmt.Fprintln(w, o.colorize(o.line(node, width), Yellow))
+		case node.HasValue:
+			padding := strings.Repeat(" ", width-len(node.Label))
+			fmt.Fprintln(w, o.colorize(node.Label, node.Color)+padding+o.separator()+node.Value)
+		default:
+			fmt.Fprintln(w, o.colorize(node.Label, node.Color))
+		}
+		o.render(w, indent+1, node.Children, node.Ordered)
+	}
+}
+
+func (o Options) line(node *Node, width int) string {
+	if !node.HasValue {
+		return node.Label
+	}
+	return node.Label + strings.Repeat(" ", width-len(node.Label)) + o.separator() + node.Value
+}
+
+func (o Options) separator() string {
+	if o.Separator == "" {
+		return " "
+	}
+	return o.Separator
+}
+
+func (o Options) colorize(text string, color int) string {
+	if o.NoColor || color == 0 {
+		return text
+	}
+	return terminal.ColorizeBold(text, terminal.Color(color))
+}
+
+// sorted returns a copy of the nodes, those with values first, each
+// group sorted by label.
+func sorted(nodes []*Node) []*Node {
+	nodes = append([]*Node{}, nodes...)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].HasValue != nodes[j].HasValue {
+			return nodes[i].HasValue
+		}
+		return nodes[i].Label < nodes[j].Label
+	})
+	return nodes
+}